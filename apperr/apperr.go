@@ -0,0 +1,162 @@
+// Package apperr defines the errors handlers return and renders them as
+// HTTP responses. Handlers return an *Error, or any other error for an
+// internal failure, and Handler turns it into the response.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Kind classifies an Error and decides its status code. It is returned to
+// clients as the error code.
+type Kind string
+
+const (
+	KindBadRequest      Kind = "bad_request"
+	KindValidation      Kind = "validation_failed"
+	KindUnauthorized    Kind = "unauthorized"
+	KindForbidden       Kind = "forbidden"
+	KindNotFound        Kind = "not_found"
+	KindConflict        Kind = "conflict"
+	KindTooManyRequests Kind = "too_many_requests"
+	KindInternal        Kind = "internal"
+)
+
+var kindStatus = map[Kind]int{
+	KindBadRequest:      fiber.StatusBadRequest,
+	KindValidation:      fiber.StatusBadRequest,
+	KindUnauthorized:    fiber.StatusUnauthorized,
+	KindForbidden:       fiber.StatusForbidden,
+	KindNotFound:        fiber.StatusNotFound,
+	KindConflict:        fiber.StatusConflict,
+	KindTooManyRequests: fiber.StatusTooManyRequests,
+	KindInternal:        fiber.StatusInternalServerError,
+}
+
+// Error is an error with a message that is safe to show to clients. Err is
+// the underlying cause, it is logged but never returned.
+type Error struct {
+	Kind    Kind
+	Message string
+	Details any
+	Err     error
+
+	// status overrides the status of Kind, for errors raised by Fiber.
+	status int
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status code the error is answered with.
+func (e *Error) Status() int {
+	if e.status != 0 {
+		return e.status
+	}
+	if status, ok := kindStatus[e.Kind]; ok {
+		return status
+	}
+	return fiber.StatusInternalServerError
+}
+
+func BadRequest(format string, args ...any) *Error {
+	return &Error{Kind: KindBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validation reports invalid input, details describe the offending fields.
+func Validation(message string, details any) *Error {
+	return &Error{Kind: KindValidation, Message: message, Details: details}
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+func Conflict(message string) *Error {
+	return &Error{Kind: KindConflict, Message: message}
+}
+
+func TooManyRequests(message string) *Error {
+	return &Error{Kind: KindTooManyRequests, Message: message}
+}
+
+// Internal reports a failure the client cannot do anything about. message
+// is returned to the client and logged together with err. An err that
+// already is an *Error is returned as it is.
+func Internal(message string, err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Kind: KindInternal, Message: message, Err: err}
+}
+
+// From returns err as an *Error. Errors raised by Fiber keep their status,
+// any other error becomes an internal error with a generic message.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return &Error{Kind: kindOf(fe.Code), Message: fe.Message, status: fe.Code}
+	}
+
+	return Internal("Internal server error", err)
+}
+
+// Status is the HTTP status code err is answered with.
+func Status(err error) int {
+	return From(err).Status()
+}
+
+// KindOf returns the Kind of err, KindInternal for errors that are not an
+// *Error.
+func KindOf(err error) Kind {
+	return From(err).Kind
+}
+
+// kindOf names the kind of a status code Fiber raised, such as
+// "method_not_allowed" for 405.
+func kindOf(status int) Kind {
+	switch {
+	case status == fiber.StatusBadRequest:
+		return KindBadRequest
+	case status == fiber.StatusUnauthorized:
+		return KindUnauthorized
+	case status == fiber.StatusForbidden:
+		return KindForbidden
+	case status == fiber.StatusNotFound:
+		return KindNotFound
+	case status == fiber.StatusConflict:
+		return KindConflict
+	case status == fiber.StatusTooManyRequests:
+		return KindTooManyRequests
+	case status >= fiber.StatusInternalServerError:
+		return KindInternal
+	}
+	return Kind(strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"))
+}
@@ -0,0 +1,69 @@
+package apperr
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MIMEProblemJSON is the media type of RFC 7807 problem details.
+const MIMEProblemJSON = "application/problem+json"
+
+type (
+	// Response is the envelope every failed request is answered with.
+	Response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Error   Body   `json:"error"`
+	}
+
+	Body struct {
+		Code    Kind `json:"code"`
+		Details any  `json:"details,omitempty"`
+	}
+
+	// Problem is the RFC 7807 form of Response, sent to clients that
+	// accept application/problem+json.
+	Problem struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+		Code     Kind   `json:"code"`
+		Errors   any    `json:"errors,omitempty"`
+	}
+)
+
+// Handler is the Fiber error handler. It answers with the status and
+// message of err, as a Response or, when the client prefers it, as a
+// Problem. Internal errors are logged with their cause.
+func Handler(c *fiber.Ctx, err error) error {
+	e := From(err)
+	status := e.Status()
+
+	if status >= fiber.StatusInternalServerError {
+		slog.ErrorContext(c.UserContext(), e.Message, "error", e.Err)
+	}
+
+	c.Status(status)
+
+	if c.Accepts(fiber.MIMEApplicationJSON, MIMEProblemJSON) == MIMEProblemJSON {
+		return c.JSON(Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   e.Message,
+			Instance: c.OriginalURL(),
+			Code:     e.Kind,
+			Errors:   e.Details,
+		}, MIMEProblemJSON)
+	}
+
+	return c.JSON(Response{
+		Success: false,
+		Message: e.Message,
+		Error:   Body{Code: e.Kind, Details: e.Details},
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-task/config"
+	"go-task/database"
+	"go-task/models"
+	"go-task/repository"
+)
+
+const assignRoleUsage = "usage: assign-role <username> <role>"
+
+// runAssignRole grants a role to a user from the command line. Registration
+// always assigns the user role, this is how the first admin is made.
+func runAssignRole(cfg config.Config, args []string) error {
+	if len(args) != 2 {
+		return errors.New(assignRoleUsage)
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect the database: %w", err)
+	}
+	repos := repository.NewGorm(db)
+	ctx := context.Background()
+
+	user, err := repos.Users.FindByUsername(ctx, args[0])
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("user %q does not exist", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	role, err := repos.Roles.FindByName(ctx, args[1])
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("role %q does not exist", args[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := repos.Users.UpdateRole(ctx, user.Id, models.Role(role.Name)); err != nil {
+		return err
+	}
+	fmt.Printf("Assigned role %s to %s, it applies from the next login.\n", role.Name, user.Username)
+	return nil
+}
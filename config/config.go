@@ -0,0 +1,523 @@
+// Package config loads the application settings once at startup. Values
+// come from, in increasing priority, the built-in defaults, an optional
+// YAML or TOML file, the .env file and the process environment.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+	EnvTest        = "test"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStoreDatabase = "database"
+)
+
+const (
+	RateLimitByIP       = "ip"
+	RateLimitByUsername = "username"
+	RateLimitByUser     = "user"
+)
+
+const (
+	MailLog  = "log"
+	MailFile = "file"
+	MailSMTP = "smtp"
+)
+
+const (
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// minRotationOverlap is the lifetime of access tokens. A retired signing key
+// has to stay valid at least as long as the tokens it signed.
+const minRotationOverlap = 15 * time.Minute
+
+// devJWTSecret signs tokens outside production when JWT_SECRET is unset, so
+// signing and verification always agree.
+const devJWTSecret = "insecure-development-secret"
+
+// defaultFiles are looked up in the working directory when CONFIG_FILE is
+// not set.
+var defaultFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+type (
+	Config struct {
+		Env             string   `yaml:"env" toml:"env" validate:"oneof=development production test"`
+		Port            int      `yaml:"port" toml:"port" validate:"min=1,max=65535"`
+		ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" validate:"gt=0"`
+		// ShutdownDelay keeps serving with /readyz failing for a while after
+		// a termination signal, so load balancers stop routing first.
+		ShutdownDelay Duration      `yaml:"shutdown_delay" toml:"shutdown_delay" validate:"gte=0,ltfield=ShutdownTimeout"`
+		Database      Database      `yaml:"database" toml:"database"`
+		JWT           JWT           `yaml:"jwt" toml:"jwt"`
+		Log           Log           `yaml:"log" toml:"log"`
+		Tracing       Tracing       `yaml:"tracing" toml:"tracing"`
+		RateLimit     RateLimit     `yaml:"rate_limit" toml:"rate_limit"`
+		Mail          Mail          `yaml:"mail" toml:"mail"`
+		PasswordReset PasswordReset `yaml:"password_reset" toml:"password_reset"`
+		Verification  Verification  `yaml:"email_verification" toml:"email_verification"`
+		MFA           MFA           `yaml:"mfa" toml:"mfa"`
+	}
+
+	// Duration is a time.Duration written like "15s" in files and the
+	// environment.
+	Duration time.Duration
+
+	Database struct {
+		Driver      string `yaml:"driver" toml:"driver" validate:"oneof=postgres sqlite"`
+		Host        string `yaml:"host" toml:"host" validate:"required_if=Driver postgres"`
+		Port        int    `yaml:"port" toml:"port" validate:"required_if=Driver postgres,max=65535"`
+		User        string `yaml:"user" toml:"user" validate:"required_if=Driver postgres"`
+		Password    string `yaml:"password" toml:"password"`
+		Name        string `yaml:"name" toml:"name" validate:"required_if=Driver postgres"`
+		Timezone    string `yaml:"timezone" toml:"timezone"`
+		AutoMigrate bool   `yaml:"auto_migrate" toml:"auto_migrate"`
+	}
+
+	// JWT configures access tokens. They are signed with Algorithm, either
+	// "EdDSA" or "RS256" with keys that rotate every RotationInterval, or
+	// "HS256" with Secret. RotationOverlap is how long a new key is
+	// published before it signs and an old one stays valid after it
+	// stopped. AcceptHS256 keeps accepting tokens signed with Secret while
+	// switching away from HS256. Secret also signs the emailed links and
+	// encrypts the stored keys.
+	JWT struct {
+		Secret           string   `yaml:"secret" toml:"secret"`
+		Algorithm        string   `yaml:"algorithm" toml:"algorithm" validate:"oneof=HS256 RS256 EdDSA"`
+		RotationInterval Duration `yaml:"rotation_interval" toml:"rotation_interval" validate:"gt=0"`
+		RotationOverlap  Duration `yaml:"rotation_overlap" toml:"rotation_overlap" validate:"ltfield=RotationInterval"`
+		AcceptHS256      bool     `yaml:"accept_hs256" toml:"accept_hs256"`
+	}
+
+	Log struct {
+		Level  string `yaml:"level" toml:"level" validate:"oneof=debug info warn error"`
+		Format string `yaml:"format" toml:"format" validate:"oneof=json text"`
+	}
+
+	Tracing struct {
+		Exporter string `yaml:"exporter" toml:"exporter" validate:"oneof=none otlp stdout file"`
+		// Endpoint is the OTLP/HTTP collector URL. When empty the exporter
+		// falls back to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+		Endpoint    string  `yaml:"endpoint" toml:"endpoint" validate:"omitempty,url"`
+		File        string  `yaml:"file" toml:"file" validate:"required_if=Exporter file"`
+		SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" validate:"gte=0,lte=1"`
+	}
+
+	// RateLimit holds the request limits of the routes open to abuse and
+	// the lockout of accounts after repeated failed logins.
+	RateLimit struct {
+		// Store is "memory" for a single instance or "database" to share
+		// the counters between instances.
+		Store    string        `yaml:"store" toml:"store" validate:"oneof=memory database"`
+		Login    RateLimitRule `yaml:"login" toml:"login"`
+		Register RateLimitRule `yaml:"register" toml:"register"`
+		// Writes limits creating, updating and deleting products.
+		Writes RateLimitRule `yaml:"writes" toml:"writes"`
+		// PasswordReset limits requests for password reset emails.
+		PasswordReset RateLimitRule `yaml:"password_reset" toml:"password_reset"`
+		// Verification limits resending email verification links.
+		Verification RateLimitRule `yaml:"verification" toml:"verification"`
+		Lockout      Lockout       `yaml:"lockout" toml:"lockout"`
+	}
+
+	// RateLimitRule allows Limit requests per Window for every value of
+	// Key: the client IP, the username or email sent in the body, or the
+	// authenticated user. A Limit of 0 turns the rule off.
+	RateLimitRule struct {
+		Limit  int      `yaml:"limit" toml:"limit" validate:"gte=0"`
+		Window Duration `yaml:"window" toml:"window" validate:"required_unless=Limit 0"`
+		Key    string   `yaml:"key" toml:"key" validate:"oneof=ip username user"`
+	}
+
+	// Lockout locks an account for Duration after Threshold failed logins
+	// in a row. Each further Threshold failures double the lock, up to
+	// MaxDuration. A Threshold of 0 turns lockout off.
+	Lockout struct {
+		Threshold   int      `yaml:"threshold" toml:"threshold" validate:"gte=0"`
+		Duration    Duration `yaml:"duration" toml:"duration" validate:"required_unless=Threshold 0"`
+		MaxDuration Duration `yaml:"max_duration" toml:"max_duration" validate:"gtefield=Duration"`
+	}
+
+	// Mail selects how emails are delivered: "smtp" through SMTP, "file"
+	// appended to File, or "log" written to the log for local development.
+	Mail struct {
+		Driver string `yaml:"driver" toml:"driver" validate:"oneof=log file smtp"`
+		From   string `yaml:"from" toml:"from" validate:"required,email"`
+		File   string `yaml:"file" toml:"file" validate:"required_if=Driver file"`
+		SMTP   SMTP   `yaml:"smtp" toml:"smtp"`
+	}
+
+	SMTP struct {
+		Host     string `yaml:"host" toml:"host"`
+		Port     int    `yaml:"port" toml:"port" validate:"max=65535"`
+		Username string `yaml:"username" toml:"username"`
+		Password string `yaml:"password" toml:"password"`
+	}
+
+	// PasswordReset configures the links emailed by the forgot password
+	// endpoint. URL is the page of the client that asks for the new
+	// password, the token is appended as the token query parameter.
+	PasswordReset struct {
+		URL      string   `yaml:"url" toml:"url" validate:"required,url"`
+		TokenTTL Duration `yaml:"token_ttl" toml:"token_ttl" validate:"gt=0"`
+	}
+
+	// Verification configures the links emailed to confirm the address of
+	// new users. URL is where the link points, by default the verify
+	// endpoint of the API itself.
+	Verification struct {
+		URL      string   `yaml:"url" toml:"url" validate:"required,url"`
+		TokenTTL Duration `yaml:"token_ttl" toml:"token_ttl" validate:"gt=0"`
+	}
+
+	// MFA configures two-factor authentication. Issuer names the account
+	// in authenticator apps, ChallengeTTL is how long after the password
+	// check the second factor may be entered.
+	MFA struct {
+		Issuer       string   `yaml:"issuer" toml:"issuer" validate:"required"`
+		ChallengeTTL Duration `yaml:"challenge_ttl" toml:"challenge_ttl" validate:"gt=0"`
+	}
+)
+
+// Default returns the settings used for anything left unconfigured.
+func Default() Config {
+	return Config{
+		Env:             EnvDevelopment,
+		Port:            3000,
+		ShutdownTimeout: Duration(15 * time.Second),
+		Database: Database{
+			Driver:      "postgres",
+			Timezone:    "Asia/Jakarta",
+			AutoMigrate: true,
+		},
+		JWT: JWT{
+			Algorithm:        JWTAlgorithmEdDSA,
+			RotationInterval: Duration(30 * 24 * time.Hour),
+			RotationOverlap:  Duration(24 * time.Hour),
+		},
+		Log: Log{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Tracing: Tracing{
+			Exporter:    TracingNone,
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimit{
+			Store:         RateLimitStoreMemory,
+			Login:         RateLimitRule{Limit: 10, Window: Duration(time.Minute), Key: RateLimitByIP},
+			Register:      RateLimitRule{Limit: 5, Window: Duration(time.Hour), Key: RateLimitByIP},
+			Writes:        RateLimitRule{Limit: 60, Window: Duration(time.Minute), Key: RateLimitByUser},
+			PasswordReset: RateLimitRule{Limit: 5, Window: Duration(time.Hour), Key: RateLimitByIP},
+			Verification:  RateLimitRule{Limit: 3, Window: Duration(time.Hour), Key: RateLimitByUser},
+			Lockout: Lockout{
+				Threshold:   5,
+				Duration:    Duration(time.Minute),
+				MaxDuration: Duration(time.Hour),
+			},
+		},
+		Mail: Mail{
+			Driver: MailLog,
+			From:   "no-reply@example.com",
+			SMTP:   SMTP{Port: 587},
+		},
+		PasswordReset: PasswordReset{
+			URL:      "http://localhost:3000/reset-password",
+			TokenTTL: Duration(30 * time.Minute),
+		},
+		Verification: Verification{
+			URL:      "http://localhost:3000/api/user/verify-email",
+			TokenTTL: Duration(48 * time.Hour),
+		},
+		MFA: MFA{
+			Issuer:       "go-task",
+			ChallengeTTL: Duration(5 * time.Minute),
+		},
+	}
+}
+
+func (c Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// Addr is the address the HTTP server listens on.
+func (c Config) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// Load reads the configuration and validates it. A missing .env or config
+// file is fine, a malformed one is an error.
+func Load() (Config, error) {
+	cfg := Default()
+
+	if err := loadFile(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	// godotenv never overrides variables that are already set.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("load .env: %w", err)
+	}
+
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func loadFile(cfg *Config) error {
+	path, explicit := os.LookupEnv("CONFIG_FILE")
+	if !explicit {
+		for _, candidate := range defaultFiles {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides cfg with every variable lookup finds.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	text := map[string]*string{
+		"APP_ENV":       &cfg.Env,
+		"DB_DRIVER":     &cfg.Database.Driver,
+		"DB_HOST":       &cfg.Database.Host,
+		"DB_USER":       &cfg.Database.User,
+		"DB_PASSWORD":   &cfg.Database.Password,
+		"DB_NAME":       &cfg.Database.Name,
+		"DB_TIMEZONE":   &cfg.Database.Timezone,
+		"JWT_SECRET":    &cfg.JWT.Secret,
+		"JWT_ALGORITHM": &cfg.JWT.Algorithm,
+		"LOG_LEVEL":     &cfg.Log.Level,
+		"LOG_FORMAT":    &cfg.Log.Format,
+
+		"TRACING_EXPORTER": &cfg.Tracing.Exporter,
+		"TRACING_ENDPOINT": &cfg.Tracing.Endpoint,
+		"TRACING_FILE":     &cfg.Tracing.File,
+		"RATE_LIMIT_STORE": &cfg.RateLimit.Store,
+
+		"MAIL_DRIVER":        &cfg.Mail.Driver,
+		"MAIL_FROM":          &cfg.Mail.From,
+		"MAIL_FILE":          &cfg.Mail.File,
+		"SMTP_HOST":          &cfg.Mail.SMTP.Host,
+		"SMTP_USERNAME":      &cfg.Mail.SMTP.Username,
+		"SMTP_PASSWORD":      &cfg.Mail.SMTP.Password,
+		"PASSWORD_RESET_URL": &cfg.PasswordReset.URL,
+
+		"EMAIL_VERIFICATION_URL": &cfg.Verification.URL,
+		"MFA_ISSUER":             &cfg.MFA.Issuer,
+	}
+	for key, target := range text {
+		if value, ok := lookup(key); ok && value != "" {
+			*target = value
+		}
+	}
+
+	ints := map[string]*int{
+		"APP_PORT":                &cfg.Port,
+		"DB_PORT":                 &cfg.Database.Port,
+		"SMTP_PORT":               &cfg.Mail.SMTP.Port,
+		"LOGIN_LOCKOUT_THRESHOLD": &cfg.RateLimit.Lockout.Threshold,
+	}
+	for key, target := range ints {
+		if value, ok := lookup(key); ok && value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*target = parsed
+		}
+	}
+
+	durations := map[string]*Duration{
+		"SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout,
+		"SHUTDOWN_DELAY":   &cfg.ShutdownDelay,
+
+		"JWT_ROTATION_INTERVAL": &cfg.JWT.RotationInterval,
+		"JWT_ROTATION_OVERLAP":  &cfg.JWT.RotationOverlap,
+
+		"LOGIN_LOCKOUT_DURATION":     &cfg.RateLimit.Lockout.Duration,
+		"LOGIN_LOCKOUT_MAX_DURATION": &cfg.RateLimit.Lockout.MaxDuration,
+		"PASSWORD_RESET_TTL":         &cfg.PasswordReset.TokenTTL,
+		"EMAIL_VERIFICATION_TTL":     &cfg.Verification.TokenTTL,
+		"MFA_CHALLENGE_TTL":          &cfg.MFA.ChallengeTTL,
+	}
+	for key, target := range durations {
+		if value, ok := lookup(key); ok && value != "" {
+			if err := target.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	rules := map[string]*RateLimitRule{
+		"RATE_LIMIT_LOGIN":    &cfg.RateLimit.Login,
+		"RATE_LIMIT_REGISTER": &cfg.RateLimit.Register,
+		"RATE_LIMIT_WRITES":   &cfg.RateLimit.Writes,
+
+		"RATE_LIMIT_PASSWORD_RESET": &cfg.RateLimit.PasswordReset,
+		"RATE_LIMIT_VERIFICATION":   &cfg.RateLimit.Verification,
+	}
+	for key, target := range rules {
+		if value, ok := lookup(key); ok && value != "" {
+			if err := parseRateLimitRule(target, value); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	if value, ok := lookup("TRACING_SAMPLE_RATIO"); ok && value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid TRACING_SAMPLE_RATIO: %w", err)
+		}
+		cfg.Tracing.SampleRatio = parsed
+	}
+
+	bools := map[string]*bool{
+		"DB_AUTO_MIGRATE":  &cfg.Database.AutoMigrate,
+		"JWT_ACCEPT_HS256": &cfg.JWT.AcceptHS256,
+	}
+	for key, target := range bools {
+		if value, ok := lookup(key); ok && value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*target = parsed
+		}
+	}
+
+	return nil
+}
+
+// parseRateLimitRule reads rules written as LIMIT/WINDOW[/KEY], such as
+// "10/1m/ip". A missing key keeps the current one, "0" turns the rule off.
+func parseRateLimitRule(rule *RateLimitRule, value string) error {
+	parts := strings.Split(value, "/")
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+	rule.Limit = limit
+	if limit == 0 && len(parts) == 1 {
+		return nil
+	}
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return errors.New("expected LIMIT/WINDOW[/KEY]")
+	}
+	if err := rule.Window.UnmarshalText([]byte(parts[1])); err != nil {
+		return err
+	}
+	if len(parts) == 3 {
+		rule.Key = parts[2]
+	}
+	return nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Validate checks the settings and fills in the development JWT secret
+// outside production. In production a missing or weak secret is an error.
+func (c *Config) Validate() error {
+	if err := validator.New().Struct(c); err != nil {
+		var errs validator.ValidationErrors
+		if errors.As(err, &errs) {
+			fields := make([]string, 0, len(errs))
+			for _, e := range errs {
+				fields = append(fields, fmt.Sprintf("%s (%s)", e.Namespace(), e.Tag()))
+			}
+			return fmt.Errorf("invalid configuration: %s", strings.Join(fields, ", "))
+		}
+		return err
+	}
+
+	if time.Duration(c.JWT.RotationOverlap) < minRotationOverlap {
+		return fmt.Errorf("invalid configuration: JWT rotation overlap must be at least %s, the lifetime of access tokens", minRotationOverlap)
+	}
+
+	if c.IsProduction() {
+		if len(c.JWT.Secret) < 32 || c.JWT.Secret == devJWTSecret {
+			return errors.New("invalid configuration: JWT_SECRET must be set to at least 32 characters in production")
+		}
+		if c.Mail.Driver == MailLog {
+			return errors.New("invalid configuration: MAIL_DRIVER must be smtp or file in production, the log driver drops emails")
+		}
+		return nil
+	}
+
+	if c.JWT.Secret == "" {
+		slog.Warn("JWT_SECRET is not set, using an insecure development secret")
+		c.JWT.Secret = devJWTSecret
+	}
+
+	return nil
+}
@@ -3,44 +3,113 @@ package database
 import (
 	"fmt"
 	"go-task/config"
-	"go-task/models"
-	"strconv"
+	"log/slog"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
-func ConnectDB() {
-	var err error
-
-	p := config.GetEnv("DB_PORT")
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
 
-	port, err := strconv.ParseUint(p, 10, 32)
-	if err != nil {
-		panic(err)
+// Open connects to the database described by cfg without touching the
+// schema. cfg.Driver selects postgres or sqlite.
+func Open(cfg config.Database) (*gorm.DB, error) {
+	switch cfg.Driver {
+	case DriverPostgres:
+		return openPostgres(cfg)
+	case DriverSQLite:
+		return openSQLite(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
 	}
+}
 
+func openPostgres(cfg config.Database) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%d sslmode=disable timezone=Asia/Jakarta",
-		config.GetEnv("DB_HOST"),
-		config.GetEnv("DB_USER"),
-		config.GetEnv("DB_PASSWORD"),
-		config.GetEnv("DB_NAME"),
-		port,
+		"host=%s user=%s password=%s dbname=%s port=%d sslmode=disable timezone=%s",
+		cfg.Host,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.Port,
+		cfg.Timezone,
 	)
 
-	DB, err = gorm.Open(postgres.New(postgres.Config{
+	return gorm.Open(postgres.New(postgres.Config{
 		DSN: dsn,
-	}), &gorm.Config{})
+	}), &gorm.Config{Logger: slogLogger{}})
+}
+
+// openSQLite opens the file named by cfg.Name, or an in-memory database when
+// the name is ":memory:".
+func openSQLite(cfg config.Database) (*gorm.DB, error) {
+	name := cfg.Name
+	if name == "" {
+		name = "go-task.db"
+	}
+	if name == ":memory:" {
+		name = "file::memory:"
+	}
+
+	db, err := gorm.Open(sqlite.Open(name+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"), &gorm.Config{Logger: slogLogger{}})
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and every connection to :memory: would
+	// get its own empty database.
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
 
+	return db, nil
+}
+
+// Connect opens DB, applies pending migrations when cfg.AutoMigrate is set
+// and seeds the built-in roles.
+func Connect(cfg config.Database) error {
+	db, err := Open(cfg)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to connect the database: %v", err))
+		return fmt.Errorf("failed to connect the database: %w", err)
+	}
+	DB = db
+
+	slog.Info("Database connection open", "driver", cfg.Driver)
+
+	if cfg.AutoMigrate {
+		applied, err := MigrateUp(DB)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the database: %w", err)
+		}
+		slog.Info("Database migrated", "applied", applied)
 	}
 
-	fmt.Println("Database connection open.")
-	DB.AutoMigrate(&models.Users{})
-	DB.AutoMigrate(&models.Products{})
-	fmt.Println("Database migrated success.")
+	return SeedRoles(DB)
+}
+
+func ConnectDB(cfg config.Database) {
+	if err := Connect(cfg); err != nil {
+		panic(err)
+	}
+}
+
+// Close closes the connection pool of DB.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"go-task/database/migrations"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationLockKey identifies the advisory lock held while migrating so that
+// several instances starting at once apply each migration only once.
+const migrationLockKey int64 = 7251988041
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// LoadMigrations reads every <version>_<name>.{up,down}.sql file in dir and
+// returns them ordered by version. Each version needs both files.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(file, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(file, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		versionPart, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if !ok || err != nil || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, name)
+		}
+
+		if direction == ".up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		result = append(result, *m)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// MigrationsFor returns the embedded migrations for the dialect of db.
+func MigrationsFor(db *gorm.DB) ([]Migration, error) {
+	switch db.Dialector.Name() {
+	case DriverPostgres:
+		return LoadMigrations(migrations.Postgres, "postgres")
+	case DriverSQLite:
+		return LoadMigrations(migrations.SQLite, "sqlite")
+	default:
+		return nil, fmt.Errorf("no migrations for dialect %s", db.Dialector.Name())
+	}
+}
+
+// withMigrationLock runs fn on a single connection that holds the migration
+// advisory lock and has the schema_migrations table available. SQLite has no
+// advisory locks and only ever allows one writer anyway.
+func withMigrationLock(db *gorm.DB, fn func(conn *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		appliedAtType := "datetime"
+		if conn.Dialector.Name() == DriverPostgres {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+				return fmt.Errorf("acquire migration lock: %w", err)
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+			appliedAtType = "timestamptz"
+		}
+
+		err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at ` + appliedAtType + ` NOT NULL
+		)`).Error
+		if err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+
+		return fn(conn)
+	})
+}
+
+func appliedMigrations(conn *gorm.DB) (map[int64]schemaMigration, error) {
+	rows := []schemaMigration{}
+	if err := conn.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, and returns how many were applied.
+func MigrateUp(db *gorm.DB) (int, error) {
+	all, err := MigrationsFor(db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(m.Up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrateDown rolls back the latest steps applied migrations.
+func MigrateDown(db *gorm.DB, steps int) (int, error) {
+	all, err := MigrationsFor(db)
+	if err != nil {
+		return 0, err
+	}
+
+	byVersion := make(map[int64]Migration, len(all))
+	for _, m := range all {
+		byVersion[m.Version] = m
+	}
+
+	count := 0
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		rows := []schemaMigration{}
+		if err := conn.Order("version DESC").Limit(steps).Find(&rows).Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			m, ok := byVersion[row.Version]
+			if !ok {
+				return fmt.Errorf("migration %d_%s is applied but its files are missing", row.Version, row.Name)
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(m.Down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{Version: m.Version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("rollback %d_%s: %w", m.Version, m.Name, err)
+			}
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	all, err := MigrationsFor(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(all))
+	err = withMigrationLock(db, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			state := MigrationState{Migration: m}
+			if row, ok := applied[m.Version]; ok {
+				appliedAt := row.AppliedAt
+				state.AppliedAt = &appliedAt
+			}
+			states = append(states, state)
+		}
+
+		return nil
+	})
+
+	return states, err
+}
+
+// SchemaVersion compares the applied migrations with the known ones.
+type SchemaVersion struct {
+	Current int64 `json:"current"`
+	Latest  int64 `json:"latest"`
+	Pending int   `json:"pending"`
+}
+
+// CheckMigrations reports the schema version against all, the migrations
+// MigrationsFor returns, without taking the migration lock or creating
+// anything, so it is cheap enough for health checks.
+func CheckMigrations(ctx context.Context, db *gorm.DB, all []Migration) (SchemaVersion, error) {
+	applied, err := appliedMigrations(db.WithContext(ctx))
+	if err != nil {
+		return SchemaVersion{}, err
+	}
+
+	var version SchemaVersion
+	for _, m := range all {
+		version.Latest = m.Version
+		if _, ok := applied[m.Version]; ok {
+			version.Current = max(version.Current, m.Version)
+		} else {
+			version.Pending++
+		}
+	}
+
+	return version, nil
+}
@@ -0,0 +1,13 @@
+// Package migrations holds the versioned SQL migrations, one directory per
+// database dialect. Files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql and are applied in version order by
+// database.MigrateUp.
+package migrations
+
+import "embed"
+
+//go:embed postgres/*.sql
+var Postgres embed.FS
+
+//go:embed sqlite/*.sql
+var SQLite embed.FS
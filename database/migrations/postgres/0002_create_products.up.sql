@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS products (
+    id bigserial PRIMARY KEY,
+    name text,
+    quantity bigint,
+    price decimal,
+    user_id bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    CONSTRAINT fk_users_products FOREIGN KEY (user_id) REFERENCES users (id)
+);
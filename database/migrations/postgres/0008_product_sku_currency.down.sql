@@ -0,0 +1,3 @@
+ALTER TABLE products DROP CONSTRAINT IF EXISTS uni_products_sku;
+ALTER TABLE products DROP COLUMN IF EXISTS currency;
+ALTER TABLE products DROP COLUMN IF EXISTS sku;
@@ -0,0 +1,4 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku text;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'IDR';
+
+ALTER TABLE products ADD CONSTRAINT uni_products_sku UNIQUE (sku);
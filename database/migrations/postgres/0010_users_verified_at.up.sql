@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at timestamptz;
+
+-- Accounts created before verification existed stay usable.
+UPDATE users SET verified_at = COALESCE(created_at, now()) WHERE verified_at IS NULL;
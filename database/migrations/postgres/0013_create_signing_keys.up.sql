@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id bigserial PRIMARY KEY,
+    kid text NOT NULL,
+    algorithm text NOT NULL,
+    private_key text NOT NULL,
+    activates_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_kid ON signing_keys (kid);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_expires_at ON signing_keys (expires_at);
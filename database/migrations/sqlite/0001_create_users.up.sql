@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    role text DEFAULT 'user',
+    email text,
+    password text NOT NULL,
+    username text NOT NULL,
+    first_name text NOT NULL,
+    last_name text,
+    created_at datetime,
+    updated_at datetime,
+    CONSTRAINT uni_users_email UNIQUE (email),
+    CONSTRAINT uni_users_username UNIQUE (username)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS products (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text,
+    quantity integer,
+    price real,
+    user_id integer,
+    created_at datetime,
+    updated_at datetime,
+    CONSTRAINT fk_users_products FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name text NOT NULL,
+    description text,
+    built_in boolean NOT NULL DEFAULT false,
+    created_at datetime,
+    updated_at datetime,
+    CONSTRAINT uni_roles_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    role_id integer NOT NULL,
+    permission text NOT NULL,
+    CONSTRAINT fk_roles_permissions FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_permission ON role_permissions (role_id, permission);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS products_fts_update;
+DROP TRIGGER IF EXISTS products_fts_delete;
+DROP TRIGGER IF EXISTS products_fts_insert;
+DROP TABLE IF EXISTS products_fts;
+ALTER TABLE products DROP COLUMN description;
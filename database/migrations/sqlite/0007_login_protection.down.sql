@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rate_limits;
+ALTER TABLE users DROP COLUMN locked_until;
+ALTER TABLE users DROP COLUMN failed_logins;
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until datetime;
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    bucket text PRIMARY KEY,
+    hits integer NOT NULL,
+    reset_at datetime NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_reset_at ON rate_limits (reset_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS uni_products_sku;
+ALTER TABLE products DROP COLUMN currency;
+ALTER TABLE products DROP COLUMN sku;
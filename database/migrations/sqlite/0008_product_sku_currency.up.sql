@@ -0,0 +1,4 @@
+ALTER TABLE products ADD COLUMN sku text;
+ALTER TABLE products ADD COLUMN currency text NOT NULL DEFAULT 'IDR';
+
+CREATE UNIQUE INDEX IF NOT EXISTS uni_products_sku ON products (sku);
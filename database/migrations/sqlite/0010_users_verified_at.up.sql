@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN verified_at datetime;
+
+-- Accounts created before verification existed stay usable.
+UPDATE users SET verified_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE verified_at IS NULL;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    last_used_at datetime,
+    revoked_at datetime,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys (key_hash);
+
+CREATE TABLE IF NOT EXISTS api_key_scopes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    api_key_id integer NOT NULL,
+    scope text NOT NULL,
+    CONSTRAINT fk_api_keys_scopes FOREIGN KEY (api_key_id) REFERENCES api_keys (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key_scope ON api_key_scopes (api_key_id, scope);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    kid text NOT NULL,
+    algorithm text NOT NULL,
+    private_key text NOT NULL,
+    activates_at datetime NOT NULL,
+    expires_at datetime NOT NULL,
+    created_at datetime
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_kid ON signing_keys (kid);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_expires_at ON signing_keys (expires_at);
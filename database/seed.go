@@ -0,0 +1,37 @@
+package database
+
+import (
+	"fmt"
+	"go-task/models"
+
+	"gorm.io/gorm"
+)
+
+// SeedRoles makes sure every built-in role exists. Permissions of existing
+// roles are left untouched so changes made through the admin API survive a
+// restart.
+func SeedRoles(db *gorm.DB) error {
+	for name, permissions := range models.BuiltInRoles {
+		role := models.Roles{Name: string(name)}
+
+		result := db.Where(models.Roles{Name: string(name)}).
+			Attrs(models.Roles{BuiltIn: true}).
+			FirstOrCreate(&role)
+		if result.Error != nil {
+			return fmt.Errorf("seed role %s: %w", name, result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		for _, permission := range permissions {
+			rp := models.RolePermissions{RoleID: role.Id, Permission: permission}
+			if err := db.Create(&rp).Error; err != nil {
+				return fmt.Errorf("seed role %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
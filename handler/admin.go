@@ -1,61 +1,75 @@
 package handler
 
 import (
-	"go-task/database"
+	"context"
+	"errors"
+	"go-task/apperr"
 	"go-task/models"
+	"go-task/repository"
 	"go-task/utils"
+	"log/slog"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func GetAllUsers(c *fiber.Ctx) error {
-	isUserAdmin := utils.IsAdmin(c)
-	if !isUserAdmin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized access this resource.",
-		})
+var UserListSpec = utils.ListSpec{
+	Sortable: map[string]string{
+		"id":         "id",
+		"username":   "username",
+		"email":      "email",
+		"created_at": "created_at",
+	},
+	Filters: map[string]utils.Filter{
+		"role":              utils.FilterEquals("role"),
+		"username_contains": utils.FilterContains("username"),
+		"created_after":     utils.FilterAfter("created_at"),
+	},
+}
+
+func (h *Handler) GetAllUsers(c *fiber.Ctx) error {
+	query, err := utils.ParseListQuery(c, UserListSpec)
+	if err != nil {
+		return err
 	}
 
-	results := []models.Users{}
-	if err := database.DB.Find(&results).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to retrieve user data.",
-			"data":    make([]interface{}, 0),
-		})
+	results, meta, err := h.users.List(c.UserContext(), query)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve user data.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "Users retrieved.",
 		"data":    results,
+		"meta":    meta,
 	})
 }
 
-func GetUserById(c *fiber.Ctx) error {
-	isUserAdmin := utils.IsAdmin(c)
-	if !isUserAdmin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized access this resource.",
-		})
+// findUser loads the user of the id parameter. Bad and unknown ids are
+// answered like middleware.RequireOwnership answers them.
+func (h *Handler) findUser(c *fiber.Ctx) (models.Users, error) {
+	userId, ok := paramID(c, "id")
+	if !ok {
+		return models.Users{}, apperr.BadRequest("Invalid resource ID.")
 	}
 
-	id := c.Params("id")
-	if id == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Missing user ID.",
-		})
+	user, err := h.users.FindByID(c.UserContext(), userId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return user, apperr.NotFound("User not found.")
 	}
+	if err != nil {
+		return user, apperr.Internal("Failed to retrieve user.", err)
+	}
+	return user, nil
+}
 
-	var user models.Users
-	if err := database.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"message": "User not found.",
-		})
+func (h *Handler) GetUserById(c *fiber.Ctx) error {
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -65,42 +79,80 @@ func GetUserById(c *fiber.Ctx) error {
 	})
 }
 
-func UpdateUser(c *fiber.Ctx) error {
-	isUserAdmin := utils.IsAdmin(c)
-	if !isUserAdmin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized access this resource.",
-		})
+// UpdateUserInput holds the profile fields an admin may change. Roles are
+// assigned with AssignUserRole, and verification, lockout and two-factor
+// state only change through their own flows.
+type UpdateUserInput struct {
+	Username  *string `json:"username" validate:"omitempty,min=3,max=32,username"`
+	Email     *string `json:"email" validate:"omitempty,email"`
+	Password  *string `json:"password" validate:"omitempty,min=8,max=16,password"`
+	FirstName *string `json:"firstName" validate:"omitempty,min=3,max=8"`
+	LastName  *string `json:"lastName"`
+}
+
+func (h *Handler) UpdateUser(c *fiber.Ctx) error {
+	var input UpdateUserInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Failed to parse request body.")
 	}
 
-	id := c.Params("id")
-	if id == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Missing user ID.",
-		})
+	if errs := h.validate(c, input); errs != nil {
+		return errs
 	}
 
-	var user models.Users
-	if err := database.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"message": "User not found.",
-		})
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
 	}
 
-	if err := c.BodyParser(&user); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse request body.",
-		})
+	if err := h.checkTarget(c, user); err != nil {
+		return err
 	}
 
-	if err := database.DB.Save(&user).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to update user.",
+	if input.Username != nil {
+		user.Username = *input.Username
+	}
+	// A new address has to be verified again.
+	emailChanged := input.Email != nil && *input.Email != user.Email
+	if emailChanged {
+		user.Email = *input.Email
+		user.VerifiedAt = nil
+	}
+	if input.FirstName != nil {
+		user.FirstName = *input.FirstName
+	}
+	if input.LastName != nil {
+		user.LastName = input.LastName
+	}
+	if input.Password != nil {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return apperr.Internal("Failed to hash password.", err)
+		}
+		user.Password = string(hashedPassword)
+	}
+
+	err = h.users.Save(c.UserContext(), &user)
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return apperr.Conflict("Email already registered.")
+	}
+	if errors.Is(err, repository.ErrDuplicateUsername) {
+		return apperr.Conflict("Username already taken.")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to update user.", err)
+	}
+
+	if input.Password != nil {
+		if err := h.revokeUserAccess(c.UserContext(), user.Id); err != nil {
+			return apperr.Internal("Failed to revoke sessions", err)
+		}
+	}
+
+	if emailChanged {
+		h.outbox.Go(c.UserContext(), func(ctx context.Context) {
+			h.sendVerification(ctx, user)
 		})
 	}
 
@@ -110,36 +162,22 @@ func UpdateUser(c *fiber.Ctx) error {
 	})
 }
 
-func DeleteUser(c *fiber.Ctx) error {
-	isUserAdmin := utils.IsAdmin(c)
-	if !isUserAdmin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized access this resource.",
-		})
+func (h *Handler) DeleteUser(c *fiber.Ctx) error {
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
 	}
 
-	id := c.Params("id")
-	if id == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Missing user ID.",
-		})
+	if err := h.checkTarget(c, user); err != nil {
+		return err
 	}
 
-	var user models.Users
-	if err := database.DB.Where("id = ?", id).First(&user).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"message": "User not found.",
-		})
+	if err := h.users.Delete(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to delete user.", err)
 	}
 
-	if err := database.DB.Delete(&user).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to delete user.",
-		})
+	if err := h.revokeUserSessions(c.UserContext(), user.Id); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to revoke sessions", "user_id", user.Id, "error", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -147,3 +185,74 @@ func DeleteUser(c *fiber.Ctx) error {
 		"message": "User deleted successfully.",
 	})
 }
+
+func (h *Handler) RevokeUserSessions(c *fiber.Ctx) error {
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkTarget(c, user); err != nil {
+		return err
+	}
+
+	if err := h.revokeUserSessions(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to revoke user sessions.", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "User sessions revoked.",
+	})
+}
+
+// UnlockUser lifts a lockout after failed logins and resets the failure
+// count.
+func (h *Handler) UnlockUser(c *fiber.Ctx) error {
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkTarget(c, user); err != nil {
+		return err
+	}
+
+	if err := h.users.ResetLoginFailures(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to unlock user.", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "User unlocked.",
+	})
+}
+
+// ResetUserMFA turns two-factor authentication off for a user who lost both
+// the authenticator and the recovery codes. Admins have to enroll again
+// before they can use the admin routes.
+func (h *Handler) ResetUserMFA(c *fiber.Ctx) error {
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkTarget(c, user); err != nil {
+		return err
+	}
+
+	if err := h.disableMFA(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to reset two-factor authentication.", err)
+	}
+
+	// Keys count as a second factor, they were made with the one that is
+	// gone now.
+	if err := h.apiKeys.RevokeByUser(c.UserContext(), user.Id, time.Now()); err != nil {
+		return apperr.Internal("Failed to revoke API keys.", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication reset.",
+	})
+}
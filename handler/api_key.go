@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/utils"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Scopes []string `json:"scopes"`
+}
+
+type UpdateAPIKeyInput struct {
+	Name   *string   `json:"name" validate:"omitempty,min=1,max=64"`
+	Scopes *[]string `json:"scopes"`
+}
+
+// NewAPIKey is a created key together with the key itself, which is only
+// returned this once.
+type NewAPIKey struct {
+	models.APIKeys
+	Key string
+}
+
+// checkScopes only accepts self scopes and scopes the role of the key
+// owner grants, so a key never allows more than its owner could do.
+func (h *Handler) checkScopes(ctx context.Context, ownerID uint, scopes []string) error {
+	for _, scope := range scopes {
+		if !models.IsKnownPermission(scope) && !models.IsSelfScope(scope) {
+			return apperr.BadRequest("Unknown scope '%s'", scope)
+		}
+	}
+
+	owner, err := h.users.FindByID(ctx, ownerID)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve user", err)
+	}
+
+	role := models.User
+	if owner.Role != nil {
+		role = *owner.Role
+	}
+	granted, err := h.roles.Permissions(ctx, role)
+	if err != nil {
+		return apperr.Internal("Failed to resolve permissions", err)
+	}
+	if slices.Contains(granted, models.PermAll) {
+		return nil
+	}
+
+	for _, scope := range scopes {
+		if !models.IsSelfScope(scope) && !slices.Contains(granted, scope) {
+			return apperr.Forbidden(fmt.Sprintf("The role '%s' does not grant '%s'.", role, scope))
+		}
+	}
+	return nil
+}
+
+func (h *Handler) CreateAPIKey(c *fiber.Ctx) error {
+	var input CreateAPIKeyInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	userId, err := utils.GetUserIDFromToken(c)
+	if err != nil {
+		return apperr.Internal("Failed to format userId", err)
+	}
+
+	if err := h.checkScopes(c.UserContext(), userId, input.Scopes); err != nil {
+		return err
+	}
+
+	raw, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		return apperr.Internal("Failed to generate API key", err)
+	}
+
+	key := models.APIKeys{
+		UserID:  userId,
+		Name:    input.Name,
+		Prefix:  prefix,
+		KeyHash: utils.HashToken(raw),
+	}
+	if err := h.apiKeys.Create(c.UserContext(), &key, input.Scopes); err != nil {
+		return apperr.Internal("Failed to create API key", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "API key created, copy it now as it is not shown again.",
+		"data":    NewAPIKey{APIKeys: key, Key: raw},
+	})
+}
+
+// GetAPIKeys lists the keys of the current user, revoked ones included.
+func (h *Handler) GetAPIKeys(c *fiber.Ctx) error {
+	userId, err := utils.GetUserIDFromToken(c)
+	if err != nil {
+		return apperr.Internal("Failed to format userId", err)
+	}
+
+	keys, err := h.apiKeys.ListByUser(c.UserContext(), userId)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve API keys", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "API keys retrieved.",
+		"data":    keys,
+	})
+}
+
+// UpdateAPIKey renames a key or replaces its scopes.
+func (h *Handler) UpdateAPIKey(c *fiber.Ctx) error {
+	key := utils.GetResource[models.APIKeys](c)
+
+	var input UpdateAPIKeyInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	if key.RevokedAt != nil {
+		return apperr.Conflict("API key is revoked.")
+	}
+
+	if input.Scopes != nil {
+		if err := h.checkScopes(c.UserContext(), key.UserID, *input.Scopes); err != nil {
+			return err
+		}
+	}
+
+	if err := h.apiKeys.Update(c.UserContext(), key.Id, input.Name, input.Scopes); err != nil {
+		return apperr.Internal("Failed to update API key", err)
+	}
+
+	updated, err := h.apiKeys.FindByID(c.UserContext(), key.Id)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve API key", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "API key updated.",
+		"data":    updated,
+	})
+}
+
+// RevokeAPIKey stops a key from working. Revoked keys stay listed.
+func (h *Handler) RevokeAPIKey(c *fiber.Ctx) error {
+	key := utils.GetResource[models.APIKeys](c)
+
+	if err := h.apiKeys.Revoke(c.UserContext(), key.Id, time.Now()); err != nil {
+		return apperr.Internal("Failed to revoke API key", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "API key revoked.",
+	})
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"go-task/config"
+	"go-task/keyset"
+	"go-task/lifecycle"
+	"go-task/mail"
+	"go-task/metrics"
+	"go-task/repository"
+	"go-task/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Dependencies is everything the HTTP layer needs from the rest of the
+// application.
+type Dependencies struct {
+	Config    config.Config
+	Repos     repository.Repositories
+	Lifecycle *lifecycle.Manager
+	Metrics   *metrics.Metrics
+	// Mailer sends the emails of the API, the log mailer when nil.
+	Mailer mail.Mailer
+	// Outbox runs the email deliveries, one of its own when nil.
+	Outbox *mail.Outbox
+	// Keys signs and verifies access tokens, rotated by its owner.
+	Keys *keyset.Keyset
+}
+
+// Handler serves the HTTP endpoints on top of the storage repositories.
+type Handler struct {
+	jwtSecret []byte
+	keys      *keyset.Keyset
+	users     repository.UserRepository
+	products  repository.ProductRepository
+	sessions  repository.SessionRepository
+	roles     repository.RoleRepository
+	status    repository.StatusRepository
+	lockout   config.Lockout
+	lifecycle *lifecycle.Manager
+	metrics   *metrics.Metrics
+
+	mailer         mail.Mailer
+	outbox         *mail.Outbox
+	passwordResets repository.PasswordResetRepository
+	passwordReset  config.PasswordReset
+	verification   config.Verification
+	recoveryCodes  repository.RecoveryCodeRepository
+	mfa            config.MFA
+	apiKeys        repository.APIKeyRepository
+	validator      *utils.Validator
+}
+
+func New(deps Dependencies) *Handler {
+	mailer := deps.Mailer
+	if mailer == nil {
+		mailer = mail.NewLog()
+	}
+	outbox := deps.Outbox
+	if outbox == nil {
+		outbox = mail.NewOutbox(mail.SendTimeout)
+	}
+
+	return &Handler{
+		jwtSecret: []byte(deps.Config.JWT.Secret),
+		keys:      deps.Keys,
+		users:     deps.Repos.Users,
+		products:  deps.Repos.Products,
+		sessions:  deps.Repos.Sessions,
+		roles:     deps.Repos.Roles,
+		status:    deps.Repos.Status,
+		lockout:   deps.Config.RateLimit.Lockout,
+		lifecycle: deps.Lifecycle,
+		metrics:   deps.Metrics,
+
+		mailer:         mailer,
+		outbox:         outbox,
+		passwordResets: deps.Repos.PasswordResets,
+		passwordReset:  deps.Config.PasswordReset,
+		verification:   deps.Config.Verification,
+		recoveryCodes:  deps.Repos.RecoveryCodes,
+		mfa:            deps.Config.MFA,
+		apiKeys:        deps.Repos.APIKeys,
+		validator: utils.NewValidator(map[string]utils.UniqueLookup{
+			"users.email":    exists(deps.Repos.Users.FindByEmail),
+			"users.username": exists(deps.Repos.Users.FindByUsername),
+			"products.sku":   exists(deps.Repos.Products.FindBySKU),
+		}),
+	}
+}
+
+// exists turns a lookup by a unique column into a check for the unique
+// validation rule.
+func exists[T any](find func(ctx context.Context, value string) (T, error)) utils.UniqueLookup {
+	return func(ctx context.Context, value string) (bool, error) {
+		_, err := find(ctx, value)
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// validate checks input and reports its invalid fields as a validation
+// error.
+func (h *Handler) validate(c *fiber.Ctx, input interface{}) error {
+	return h.validator.Handle(c, input)
+}
+
+// paramID parses the numeric route parameter name. ok is false when the
+// parameter is not a valid ID.
+func paramID(c *fiber.Ctx, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Params(name), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-task/version"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readinessTimeout bounds how long a readiness probe waits on the database.
+const readinessTimeout = 2 * time.Second
+
+// Healthz reports that the process is alive. It deliberately checks nothing
+// else, a broken dependency should not get the process restarted.
+func (h *Handler) Healthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "alive",
+	})
+}
+
+// Readyz reports whether the instance should receive traffic: the database
+// answers, every migration is applied and no shutdown is in progress. The
+// endpoint is public, so failed checks only report that they failed and
+// the reason goes to the log.
+func (h *Handler) Readyz(c *fiber.Ctx) error {
+	ready := true
+	checks := fiber.Map{}
+	fail := func(check string, err error) {
+		ready = false
+		checks[check] = fiber.Map{"status": "fail"}
+		slog.WarnContext(c.UserContext(), "Readiness check failed", "check", check, "error", err)
+	}
+
+	if h.lifecycle != nil && h.lifecycle.ShuttingDown() {
+		fail("shutdown", errors.New("shutting down"))
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), readinessTimeout)
+	defer cancel()
+
+	started := time.Now()
+	if err := h.status.Ping(ctx); err != nil {
+		fail("database", err)
+	} else {
+		checks["database"] = fiber.Map{"status": "ok", "latencyMs": time.Since(started).Milliseconds()}
+	}
+
+	schema, err := h.status.SchemaVersion(ctx)
+	switch {
+	case err != nil:
+		fail("migrations", err)
+	case schema.Pending > 0:
+		fail("migrations", fmt.Errorf("%d migrations pending", schema.Pending))
+	default:
+		checks["migrations"] = fiber.Map{"status": "ok", "schema": schema}
+	}
+
+	status, message := fiber.StatusOK, "ready"
+	if !ready {
+		status, message = fiber.StatusServiceUnavailable, "not ready"
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": ready,
+		"message": message,
+		"data": fiber.Map{
+			"checks": checks,
+			"build":  version.Get(),
+		},
+	})
+}
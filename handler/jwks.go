@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. It has to stay
+// well below the rotation overlap, which is at least 15 minutes.
+const jwksMaxAge = 300
+
+// JWKS serves the public keys access tokens are verified with, as a plain
+// JSON Web Key Set for other services to use.
+func (h *Handler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	return c.Status(fiber.StatusOK).JSON(h.keys.JWKS())
+}
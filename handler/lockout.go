@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"go-task/apperr"
+	"go-task/models"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lockDuration returns how long an account is locked after its failures-th
+// failed login in a row, or zero when it stays unlocked. Every Threshold
+// failures lock it again, each time for twice as long.
+func (h *Handler) lockDuration(failures int) time.Duration {
+	threshold := h.lockout.Threshold
+	if threshold == 0 || failures < threshold || failures%threshold != 0 {
+		return 0
+	}
+
+	maxDuration := time.Duration(h.lockout.MaxDuration)
+	duration := time.Duration(h.lockout.Duration)
+	for i := 1; i < failures/threshold && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	return min(duration, maxDuration)
+}
+
+// recordLoginFailure counts a failed login of user and locks the account
+// once the failures reach the threshold.
+func (h *Handler) recordLoginFailure(ctx context.Context, user models.Users) {
+	failures, err := h.users.RecordLoginFailure(ctx, user.Id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record failed login", "user_id", user.Id, "error", err)
+		return
+	}
+
+	duration := h.lockDuration(failures)
+	if duration == 0 {
+		return
+	}
+
+	if err := h.users.Lock(ctx, user.Id, time.Now().Add(duration)); err != nil {
+		slog.ErrorContext(ctx, "Failed to lock account", "user_id", user.Id, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Account locked after failed logins", "user_id", user.Id, "failures", failures, "duration", duration.String())
+}
+
+// accountLocked refuses the login of a locked user with 429 and
+// Retry-After.
+func (h *Handler) accountLocked(c *fiber.Ctx, user models.Users) error {
+	if user.LockedUntil == nil || !time.Now().Before(*user.LockedUntil) {
+		return nil
+	}
+
+	h.metrics.FailedLogins.WithLabelValues("locked").Inc()
+
+	retryAfter := int(math.Ceil(time.Until(*user.LockedUntil).Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return apperr.TooManyRequests("Too many failed logins, the account is temporarily locked.")
+}
@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mfaChallengePurpose keeps MFA challenge tokens from being accepted as any
+// other signed token.
+const mfaChallengePurpose = "mfa-challenge"
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+// MFAChallenge is what LoginUser answers instead of a session when the user
+// has two-factor authentication on. Token is passed on to LoginMFA.
+type MFAChallenge struct {
+	Token     string    `json:"mfaToken"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type MFALoginInput struct {
+	Token string `json:"mfaToken" validate:"required"`
+	Code  string `json:"code" validate:"required,max=32"`
+}
+
+type MFACodeInput struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// MFAEnrollment is what an authenticator app needs to add the account. URI
+// is meant to be shown as a QR code, Secret to be typed in by hand.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFAConfirmation carries the new access token and the recovery codes,
+// which are only ever shown once.
+type MFAConfirmation struct {
+	Token         string   `json:"token"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func isAdmin(user models.Users) bool {
+	return user.Role != nil && *user.Role == models.Admin
+}
+
+func (h *Handler) currentUser(c *fiber.Ctx) (models.Users, error) {
+	userId, err := utils.GetUserIDFromToken(c)
+	if err != nil {
+		return models.Users{}, apperr.Internal("Failed to format userId", err)
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), userId)
+	if err != nil {
+		return models.Users{}, apperr.Internal("Failed to retrieve user", err)
+	}
+	return user, nil
+}
+
+// mfaChallenge answers a correct password of a user with two-factor
+// authentication on.
+func (h *Handler) mfaChallenge(c *fiber.Ctx, user models.Users) error {
+	expires := time.Now().Add(time.Duration(h.mfa.ChallengeTTL))
+	token := utils.SignToken(h.jwtSecret, mfaChallengePurpose, strconv.FormatUint(uint64(user.Id), 10), expires)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Enter the code from your authenticator app.",
+		"data":    MFAChallenge{Token: token, ExpiresAt: expires},
+	})
+}
+
+// checkTOTP accepts a current TOTP code of user that was not used before.
+func (h *Handler) checkTOTP(ctx context.Context, user models.Users, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	step, ok := utils.ValidateTOTP(*user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+	return h.users.UseTOTPStep(ctx, user.Id, step)
+}
+
+// checkSecondFactor accepts a TOTP code or an unused recovery code of user.
+func (h *Handler) checkSecondFactor(ctx context.Context, user models.Users, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	if _, ok := utils.ValidateTOTP(*user.TOTPSecret, strings.TrimSpace(code), time.Now()); ok {
+		return h.checkTOTP(ctx, user, code)
+	}
+
+	err := h.recoveryCodes.Consume(ctx, user.Id, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCodes replaces the recovery codes of a user and returns the
+// new ones. Only their hashes are stored.
+func (h *Handler) newRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+
+	if err := h.recoveryCodes.Replace(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// LoginMFA completes a login with the challenge token from LoginUser and a
+// TOTP or recovery code. Wrong codes count as failed logins.
+func (h *Handler) LoginMFA(c *fiber.Ctx) error {
+	var input MFALoginInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	expired := apperr.Unauthorized("Login expired, please login again")
+
+	payload, err := utils.VerifySignedToken(h.jwtSecret, mfaChallengePurpose, input.Token)
+	if err != nil {
+		return expired
+	}
+	userId, err := strconv.ParseUint(payload, 10, 32)
+	if err != nil {
+		return expired
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), uint(userId))
+	if err != nil || user.MFAEnabledAt == nil {
+		return expired
+	}
+
+	if err := h.accountLocked(c, user); err != nil {
+		return err
+	}
+
+	ok, err := h.checkSecondFactor(c.UserContext(), user, input.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check authentication code", err)
+	}
+	if !ok {
+		h.metrics.FailedLogins.WithLabelValues("bad_mfa_code").Inc()
+		h.recordLoginFailure(c.UserContext(), user)
+		return apperr.Unauthorized("Invalid authentication code")
+	}
+
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.users.ResetLoginFailures(c.UserContext(), user.Id); err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to reset failed logins", "user_id", user.Id, "error", err)
+		}
+	}
+
+	token, err := h.openSession(c, user)
+	if err != nil {
+		return apperr.Internal("Failed to create session", err)
+	}
+	h.metrics.Logins.Inc()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Login success",
+		"data":    token,
+	})
+}
+
+// EnrollMFA creates a new TOTP secret for the current user. It only takes
+// effect once a code is confirmed with ConfirmMFA.
+func (h *Handler) EnrollMFA(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabledAt != nil {
+		return apperr.Conflict("Two-factor authentication is already enabled.")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return apperr.Internal("Failed to generate secret", err)
+	}
+	if err := h.users.SetTOTP(c.UserContext(), user.Id, &secret, nil); err != nil {
+		return apperr.Internal("Failed to start enrollment", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Add the account to your authenticator app, then confirm a code.",
+		"data":    MFAEnrollment{Secret: secret, URI: utils.TOTPURI(h.mfa.Issuer, user.Email, secret)},
+	})
+}
+
+// ConfirmMFA turns two-factor authentication on with a first code from the
+// enrolled app. Every other session is revoked and the current one is
+// replaced by one that counts as opened with a second factor.
+func (h *Handler) ConfirmMFA(c *fiber.Ctx) error {
+	var input MFACodeInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid request body")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabledAt != nil {
+		return apperr.Conflict("Two-factor authentication is already enabled.")
+	}
+	if user.TOTPSecret == nil {
+		return apperr.BadRequest("Start the enrollment first")
+	}
+
+	ok, err := h.checkTOTP(c.UserContext(), user, input.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check authentication code", err)
+	}
+	if !ok {
+		return apperr.BadRequest("Invalid authentication code")
+	}
+
+	now := time.Now()
+	if err := h.users.SetTOTP(c.UserContext(), user.Id, user.TOTPSecret, &now); err != nil {
+		return apperr.Internal("Failed to enable two-factor authentication", err)
+	}
+	user.MFAEnabledAt = &now
+
+	codes, err := h.newRecoveryCodes(c.UserContext(), user.Id)
+	if err != nil {
+		return apperr.Internal("Failed to generate recovery codes", err)
+	}
+
+	// Sessions opened with the password alone must not carry on.
+	if err := h.revokeUserSessions(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to revoke sessions", err)
+	}
+
+	token, err := h.openSession(c, user)
+	if err != nil {
+		return apperr.Internal("Failed to create session", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication enabled. Keep the recovery codes somewhere safe, they are not shown again.",
+		"data":    MFAConfirmation{Token: token, RecoveryCodes: codes},
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user,
+// which invalidates the old ones.
+func (h *Handler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var input MFACodeInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid request body")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabledAt == nil {
+		return apperr.Conflict("Two-factor authentication is not enabled.")
+	}
+
+	ok, err := h.checkSecondFactor(c.UserContext(), user, input.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check authentication code", err)
+	}
+	if !ok {
+		return apperr.BadRequest("Invalid authentication code")
+	}
+
+	codes, err := h.newRecoveryCodes(c.UserContext(), user.Id)
+	if err != nil {
+		return apperr.Internal("Failed to generate recovery codes", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Recovery codes replaced.",
+		"data":    codes,
+	})
+}
+
+// DisableMFA turns two-factor authentication off for the current user.
+// Admins cannot, it is mandatory for them.
+func (h *Handler) DisableMFA(c *fiber.Ctx) error {
+	var input MFACodeInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid request body")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if isAdmin(user) {
+		return apperr.Forbidden("Two-factor authentication is mandatory for admins.")
+	}
+	if user.MFAEnabledAt == nil {
+		return apperr.Conflict("Two-factor authentication is not enabled.")
+	}
+
+	ok, err := h.checkSecondFactor(c.UserContext(), user, input.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check authentication code", err)
+	}
+	if !ok {
+		return apperr.BadRequest("Invalid authentication code")
+	}
+
+	if err := h.disableMFA(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to disable two-factor authentication", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Two-factor authentication disabled.",
+	})
+}
+
+func (h *Handler) disableMFA(ctx context.Context, userID uint) error {
+	if err := h.users.SetTOTP(ctx, userID, nil, nil); err != nil {
+		return err
+	}
+	return h.recoveryCodes.Replace(ctx, userID, nil)
+}
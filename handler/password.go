@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-task/apperr"
+	"go-task/mail"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword emails a password reset link if the address belongs to a
+// user. The lookup and the email happen in the background and the answer is
+// always the same, so clients cannot tell which addresses are registered.
+func (h *Handler) ForgotPassword(c *fiber.Ctx) error {
+	var input ForgotPasswordInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid request body")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	h.outbox.Go(c.UserContext(), func(ctx context.Context) {
+		h.sendPasswordReset(ctx, input.Email)
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success": true,
+		"message": "If the email is registered, a password reset link is on its way.",
+	})
+}
+
+func (h *Handler) sendPasswordReset(ctx context.Context, email string) {
+	user, err := h.users.FindByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		slog.InfoContext(ctx, "Password reset requested for an unknown email")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up user for password reset", "error", err)
+		return
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to generate password reset token", "error", err)
+		return
+	}
+
+	ttl := time.Duration(h.passwordReset.TokenTTL)
+	record := models.PasswordResetTokens{
+		UserID:    user.Id,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := h.passwordResets.Create(ctx, &record); err != nil {
+		slog.ErrorContext(ctx, "Failed to store password reset token", "user_id", user.Id, "error", err)
+		return
+	}
+
+	link, err := withToken(h.passwordReset.URL, token)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid password reset URL", "error", err)
+		return
+	}
+
+	err = h.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to choose a new password. It works once and expires in %s.\n\n%s\n\n"+
+			"If you did not ask for a new password you can ignore this email.\n", user.FirstName, ttl, link),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send password reset email", "user_id", user.Id, "error", err)
+	}
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=16,password"`
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// logs the user out everywhere.
+func (h *Handler) ResetPassword(c *fiber.Ctx) error {
+	var input ResetPasswordInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid request body")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	token, err := h.passwordResets.Consume(c.UserContext(), utils.HashToken(input.Token))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		return apperr.BadRequest("Invalid or expired reset token")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to reset password", err)
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), token.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return apperr.BadRequest("Invalid or expired reset token")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to reset password", err)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return apperr.Internal("Failed to hash password", err)
+	}
+
+	user.Password = string(hashedPassword)
+	if user.VerifiedAt == nil {
+		// The reset link reached the user, which proves the address.
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+	if err := h.users.Save(c.UserContext(), &user); err != nil {
+		return apperr.Internal("Failed to reset password", err)
+	}
+
+	if err := h.revokeUserAccess(c.UserContext(), user.Id); err != nil {
+		return apperr.Internal("Failed to revoke sessions", err)
+	}
+
+	// Whoever reset the password owns the mailbox, a lock left by failed
+	// logins no longer protects anything.
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.users.ResetLoginFailures(c.UserContext(), user.Id); err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to reset failed logins", "user_id", user.Id, "error", err)
+		}
+	}
+
+	clearSessionCookies(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Password reset, please login again.",
+	})
+}
@@ -2,143 +2,185 @@ package handler
 
 import (
 	"errors"
-	"go-task/database"
+	"go-task/apperr"
 	"go-task/models"
+	"go-task/repository"
 	"go-task/utils"
-	"log"
-	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
-func GetAllProducts(c *fiber.Ctx) error {
+var ProductListSpec = utils.ListSpec{
+	Sortable: map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"price":      "price",
+		"quantity":   "quantity",
+		"created_at": "created_at",
+	},
+	Filters: map[string]utils.Filter{
+		"min_price":     utils.FilterMinFloat("price"),
+		"max_price":     utils.FilterMaxFloat("price"),
+		"name_contains": utils.FilterContains("name"),
+		"owner_id":      utils.FilterUint("user_id"),
+		"created_after": utils.FilterAfter("created_at"),
+	},
+}
 
-	isUserAdmin := utils.IsAdmin(c)
-	if !isUserAdmin {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Unauthorized access this resource.",
-		})
+func (h *Handler) GetAllProducts(c *fiber.Ctx) error {
+	query, err := utils.ParseListQuery(c, ProductListSpec)
+	if err != nil {
+		return err
 	}
 
-	products := []models.Products{}
-	if err := database.DB.Find(&products).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to retrieve product data.",
-			"data":    make([]interface{}, 0),
-		})
+	products, meta, err := h.products.List(c.UserContext(), query)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve product data.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "Products retrieved.",
 		"data":    products,
+		"meta":    meta,
 	})
 }
 
-func GetUserProducts(c *fiber.Ctx) error {
+func (h *Handler) GetUserProducts(c *fiber.Ctx) error {
 
 	userId, err := utils.GetUserIDFromToken(c)
-	log.Printf("Retrieved userId: %v", userId)
 	if err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server error",
-		})
+		return apperr.Internal("Failed to format userId", err)
 	}
 
-	products := []models.Products{}
-	if err := database.DB.Where("user_id = ?", userId).Find(&products).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to retrieve product data.",
-			"data":    make([]interface{}, 0),
-		})
+	query, err := utils.ParseListQuery(c, ProductListSpec)
+	if err != nil {
+		return err
+	}
+
+	query.Where("user_id", userId)
+
+	products, meta, err := h.products.List(c.UserContext(), query)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve product data.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
 		"message": "Products retrieved.",
 		"data":    products,
+		"meta":    meta,
 	})
 }
 
-func GetProductById(c *fiber.Ctx) error {
+// SearchProducts returns the products best matching the q query parameter,
+// see ProductRepository.Search for how matches are ranked. Without
+// products:read:any only the caller's own products are searched.
+func (h *Handler) SearchProducts(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || len(q) > 100 {
+		return apperr.BadRequest("Search query q must be between 1 and 100 characters")
+	}
 
-	id := c.Params("id")
+	limit, err := utils.ParseLimit(c)
+	if err != nil {
+		return err
+	}
 
-	if id == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid params :id is required",
-		})
+	var ownerID uint
+	if granted, _ := utils.GetPermissions(c); !models.Grants(granted, models.PermProductsReadAny) {
+		if ownerID, err = utils.GetUserIDFromToken(c); err != nil {
+			return apperr.Internal("Failed to format userId", err)
+		}
 	}
 
-	result := models.Products{}
-	err := database.DB.First(&result, id).Error
+	results, mode, err := h.products.Search(c.UserContext(), q, ownerID, limit)
+	if err != nil {
+		return apperr.Internal("Failed to search products.", err)
+	}
 
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"success": false,
-			"message": "Data you try to search not found",
-			"data":    nil,
-		})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Products retrieved.",
+		"data":    results,
+		"meta": fiber.Map{
+			"query": q,
+			"mode":  mode,
+			"total": len(results),
+		},
+	})
+}
+
+func (h *Handler) GetProductById(c *fiber.Ctx) error {
+	productId, ok := paramID(c, "id")
+	if !ok {
+		return apperr.BadRequest("Invalid resource ID.")
+	}
+
+	result, err := h.products.FindByID(c.UserContext(), productId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return apperr.NotFound("Product not found.")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to retrieve product.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"message": "success",
+		"message": "Product retrieved.",
 		"data":    result,
 	})
 }
 
-func CreateProduct(c *fiber.Ctx) error {
-	type CreateProductInput struct {
-		Name     string  `json:"name" validate:"required,min=3,max=25"`
-		Quantity int     `json:"quantity" validate:"required,min=1"`
-		Price    float64 `json:"price" validate:"required,min=1"`
-	}
+type CreateProductInput struct {
+	Name        string  `json:"name" validate:"required,min=3,max=25"`
+	Description string  `json:"description" validate:"max=1000"`
+	Quantity    int     `json:"quantity" validate:"required,min=1"`
+	Price       float64 `json:"price" validate:"required,min=1"`
+	SKU         *string `json:"sku" validate:"omitempty,sku,unique=products.sku"`
+	Currency    string  `json:"currency" validate:"omitempty,currency"`
+}
 
+func (h *Handler) CreateProduct(c *fiber.Ctx) error {
 	userId, err := utils.GetUserIDFromToken(c)
 	if err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server error",
-		})
+		return apperr.Internal("Failed to format userId", err)
 	}
 
 	var input CreateProductInput
 
 	if err := c.BodyParser(&input); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid body request",
-		})
+		return apperr.BadRequest("Invalid body request")
 	}
 
-	if errs := utils.ValidationHandler(input); errs != nil {
+	if errs := h.validate(c, input); errs != nil {
 		return errs
 	}
 
 	product := models.Products{
-		Name:     input.Name,
-		Quantity: uint(input.Quantity),
-		Price:    input.Price,
-		UserID:   uint(userId),
+		Name:        input.Name,
+		Description: input.Description,
+		Quantity:    uint(input.Quantity),
+		Price:       input.Price,
+		SKU:         input.SKU,
+		Currency:    input.Currency,
+		UserID:      uint(userId),
+	}
+	if product.Currency == "" {
+		product.Currency = models.DefaultCurrency
 	}
 
-	if err := database.DB.Create(&product).Error; err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": true,
-			"message": "Failed to create product.",
-		})
+	err = h.products.Create(c.UserContext(), &product)
+	if errors.Is(err, repository.ErrDuplicateSKU) {
+		return apperr.Conflict("SKU already taken")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to create product.", err)
 	}
 
+	h.metrics.ProductsCreated.Inc()
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"success": true,
 		"message": "Product successfully created",
@@ -147,107 +189,59 @@ func CreateProduct(c *fiber.Ctx) error {
 
 }
 
-func UpdateProduct(c *fiber.Ctx) error {
-	type UpdateProductInput struct {
-		Name     *string  `json:"name"`
-		Quantity *int     `json:"quantity"`
-		Price    *float64 `json:"price"`
-	}
-
-	id := c.Params("id")
-
-	if id == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"succcess": false,
-			"message":  "Product ID must be required to update",
-		})
-	}
+type UpdateProductInput struct {
+	Name        *string  `json:"name" validate:"omitempty,min=3,max=25"`
+	Description *string  `json:"description" validate:"omitempty,max=1000"`
+	Quantity    *int     `json:"quantity" validate:"omitempty,min=1"`
+	Price       *float64 `json:"price" validate:"omitempty,min=1"`
+	SKU         *string  `json:"sku" validate:"omitempty,sku"`
+	Currency    *string  `json:"currency" validate:"omitempty,currency"`
+}
 
-	userId, err := utils.GetUserIDFromToken(c)
-	if err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server error",
-		})
-	}
+func (h *Handler) UpdateProduct(c *fiber.Ctx) error {
+	product := utils.GetResource[models.Products](c)
 
 	var input UpdateProductInput
-	log.Printf("Incoming Update product input: %v", input)
 
 	if err := c.BodyParser(&input); err != nil {
-		log.Println("Failed to parse body request")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid body request.",
-		})
+		return apperr.BadRequest("Invalid body request.")
 	}
 
-	productId, err := strconv.ParseUint(id, 10, 32)
-	if err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success":  false,
-			"messaage": "Failed to update product",
-		})
+	if errs := h.validate(c, input); errs != nil {
+		return errs
 	}
 
-	updates := make(map[string]interface{})
-
-	if input.Name != nil {
-		updates["name"] = *input.Name
+	changes := repository.ProductChanges{
+		Name:        input.Name,
+		Description: input.Description,
+		Price:       input.Price,
+		SKU:         input.SKU,
+		Currency:    input.Currency,
 	}
 	if input.Quantity != nil {
-		updates["quantity"] = *input.Quantity
-	}
-	if input.Price != nil {
-		updates["price"] = *input.Price
+		quantity := uint(*input.Quantity)
+		changes.Quantity = &quantity
 	}
 
-	if len(updates) > 0 {
-		if err := database.DB.Model(&models.Products{Id: uint(productId), UserID: userId}).Updates(updates).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"message": "success",
-			})
-		}
+	err := h.products.Update(c.UserContext(), product.Id, changes)
+	if errors.Is(err, repository.ErrDuplicateSKU) {
+		return apperr.Conflict("SKU already taken")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to update product.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"message": "success",
+		"message": "Product updated successfully.",
 	})
 }
 
-func DeleteProductById(c *fiber.Ctx) error {
-	id := c.Params("id")
-	productId, err := strconv.ParseUint(id, 10, 32)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": true,
-			"message": "Product ID must be required",
-		})
-	}
-
-	_, err = utils.GetUserIDFromToken(c)
-	if err != nil {
-		log.Printf("Failed to format userId: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server error",
-		})
-	}
-
-	product := models.Products{
-		Id: uint(productId),
-	}
+func (h *Handler) DeleteProductById(c *fiber.Ctx) error {
+	product := utils.GetResource[models.Products](c)
 
-	if err := database.DB.Delete(&product).Error; err != nil {
-		log.Printf("Failed to delete product: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to delete the product.",
-		})
+	if err := h.products.Delete(c.UserContext(), product.Id); err != nil {
+		return apperr.Internal("Failed to delete the product.", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"errors"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !models.IsKnownPermission(permission) {
+			return apperr.BadRequest("Unknown permission '%s'", permission)
+		}
+	}
+	return nil
+}
+
+// checkGrantable refuses permissions the caller does not hold, so nobody
+// can hand out more than they have.
+func checkGrantable(c *fiber.Ctx, permissions []string) error {
+	granted, _ := utils.GetPermissions(c)
+	if !models.Grants(granted, permissions...) {
+		return apperr.Forbidden("You cannot grant permissions you do not have.")
+	}
+	return nil
+}
+
+// checkRoleGrantable refuses to touch a role with permissions the caller
+// does not hold.
+func (h *Handler) checkRoleGrantable(c *fiber.Ctx, role models.Role) error {
+	permissions, err := h.roles.Permissions(c.UserContext(), role)
+	if err != nil {
+		return apperr.Internal("Failed to resolve permissions", err)
+	}
+	return checkGrantable(c, permissions)
+}
+
+// checkTarget refuses to act on a user whose role has permissions the
+// caller does not hold, so support cannot take over an admin account.
+func (h *Handler) checkTarget(c *fiber.Ctx, user models.Users) error {
+	role := models.User
+	if user.Role != nil {
+		role = *user.Role
+	}
+	permissions, err := h.roles.Permissions(c.UserContext(), role)
+	if err != nil {
+		return apperr.Internal("Failed to resolve permissions", err)
+	}
+	granted, _ := utils.GetPermissions(c)
+	if !models.Grants(granted, permissions...) {
+		return apperr.Forbidden("You cannot manage a user with permissions you do not have.")
+	}
+	return nil
+}
+
+// findRole loads the role of the id parameter, see findUser.
+func (h *Handler) findRole(c *fiber.Ctx) (models.Roles, error) {
+	roleId, ok := paramID(c, "id")
+	if !ok {
+		return models.Roles{}, apperr.BadRequest("Invalid resource ID.")
+	}
+
+	role, err := h.roles.FindByID(c.UserContext(), roleId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return role, apperr.NotFound("Role not found.")
+	}
+	if err != nil {
+		return role, apperr.Internal("Failed to retrieve role.", err)
+	}
+	return role, nil
+}
+
+func (h *Handler) GetAllRoles(c *fiber.Ctx) error {
+	roles, err := h.roles.List(c.UserContext())
+	if err != nil {
+		return apperr.Internal("Failed to retrieve role data.", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Roles retrieved.",
+		"data":    roles,
+	})
+}
+
+type CreateRoleInput struct {
+	Name        string   `json:"name" validate:"required,min=3,max=32"`
+	Description string   `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions"`
+}
+
+func (h *Handler) CreateRole(c *fiber.Ctx) error {
+	var input CreateRoleInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	if err := validatePermissions(input.Permissions); err != nil {
+		return err
+	}
+
+	if err := checkGrantable(c, input.Permissions); err != nil {
+		return err
+	}
+
+	role := models.Roles{
+		Name:        input.Name,
+		Description: input.Description,
+	}
+
+	err := h.roles.Create(c.UserContext(), &role, input.Permissions)
+	if errors.Is(err, repository.ErrDuplicateName) {
+		return apperr.Conflict("Role already exists")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to create role.", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Role successfully created",
+		"data":    role,
+	})
+}
+
+type UpdateRoleInput struct {
+	Description *string   `json:"description" validate:"omitempty,max=255"`
+	Permissions *[]string `json:"permissions"`
+}
+
+func (h *Handler) UpdateRole(c *fiber.Ctx) error {
+	role, err := h.findRole(c)
+	if err != nil {
+		return err
+	}
+
+	if role.Name == string(models.Admin) {
+		return apperr.Forbidden("The admin role cannot be modified.")
+	}
+
+	if err := h.checkRoleGrantable(c, models.Role(role.Name)); err != nil {
+		return err
+	}
+
+	var input UpdateRoleInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	if input.Permissions != nil {
+		if err := validatePermissions(*input.Permissions); err != nil {
+			return err
+		}
+		if err := checkGrantable(c, *input.Permissions); err != nil {
+			return err
+		}
+	}
+
+	err = h.roles.Update(c.UserContext(), role.Id, input.Description, input.Permissions)
+	if err != nil {
+		return apperr.Internal("Failed to update role.", err)
+	}
+
+	if updated, err := h.roles.FindByID(c.UserContext(), role.Id); err == nil {
+		role = updated
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Role updated successfully.",
+		"data":    role,
+	})
+}
+
+func (h *Handler) DeleteRole(c *fiber.Ctx) error {
+	role, err := h.findRole(c)
+	if err != nil {
+		return err
+	}
+
+	if role.BuiltIn {
+		return apperr.Forbidden("Built-in roles cannot be deleted.")
+	}
+
+	assigned, err := h.users.CountByRole(c.UserContext(), models.Role(role.Name))
+	if err != nil {
+		return apperr.Internal("Failed to delete role.", err)
+	}
+	if assigned > 0 {
+		return apperr.Conflict("Role is still assigned to users.")
+	}
+
+	if err := h.roles.Delete(c.UserContext(), role.Id); err != nil {
+		return apperr.Internal("Failed to delete role.", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Role deleted successfully.",
+	})
+}
+
+type AssignRoleInput struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func (h *Handler) AssignUserRole(c *fiber.Ctx) error {
+	var input AssignRoleInput
+
+	if err := c.BodyParser(&input); err != nil {
+		return apperr.BadRequest("Invalid body request")
+	}
+
+	if errs := h.validate(c, input); errs != nil {
+		return errs
+	}
+
+	user, err := h.findUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkTarget(c, user); err != nil {
+		return err
+	}
+
+	role, err := h.roles.FindByName(c.UserContext(), input.Role)
+	if errors.Is(err, repository.ErrNotFound) {
+		return apperr.BadRequest("Role does not exist.")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to assign role.", err)
+	}
+
+	if err := h.checkRoleGrantable(c, models.Role(role.Name)); err != nil {
+		return err
+	}
+
+	if err := h.users.UpdateRole(c.UserContext(), user.Id, models.Role(role.Name)); err != nil {
+		return apperr.Internal("Failed to assign role.", err)
+	}
+
+	// The role is carried in the access token, force the user to log in
+	// again so the new permissions apply right away.
+	if err := h.revokeUserSessions(c.UserContext(), user.Id); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to revoke sessions", "user_id", user.Id, "error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Role assigned successfully.",
+	})
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// newSession issues a new access token and a refresh token belonging to
+// familyID. The returned record still has to be stored by the caller.
+// Sessions of users with two-factor authentication on count as opened with
+// a second factor: logins ask for it, and enabling it revokes the sessions
+// opened before.
+func (h *Handler) newSession(user models.Users, familyID string) (string, models.RefreshTokens, string, error) {
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", models.RefreshTokens{}, "", err
+	}
+
+	record := models.RefreshTokens{
+		UserID:    user.Id,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		AccessJti: uuid.NewString(),
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}
+
+	accessToken := utils.CreateJWT(utils.JwtCredentialStruct{
+		Id:       user.Id,
+		Username: user.Username,
+		Role:     *user.Role,
+		Jti:      record.AccessJti,
+		MFA:      user.MFAEnabledAt != nil,
+	}, h.keys)
+	if accessToken == "" {
+		return "", models.RefreshTokens{}, "", errors.New("failed to sign access token")
+	}
+
+	return accessToken, record, refreshToken, nil
+}
+
+// openSession starts a new token family for user, sets the session cookies
+// and returns the access token.
+func (h *Handler) openSession(c *fiber.Ctx, user models.Users) (string, error) {
+	token, session, refreshToken, err := h.newSession(user, uuid.NewString())
+	if err == nil {
+		err = h.sessions.CreateRefreshToken(c.UserContext(), &session)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	setSessionCookies(c, token, refreshToken)
+	return token, nil
+}
+
+func setSessionCookies(c *fiber.Ctx, accessToken, refreshToken string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     utils.AccessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(utils.AccessTokenTTL.Seconds()),
+		Secure:   false,
+		HTTPOnly: true,
+		SameSite: "lax",
+	})
+
+	c.Cookie(&fiber.Cookie{
+		Name:     utils.RefreshTokenCookie,
+		Value:    refreshToken,
+		Path:     "/api/user",
+		MaxAge:   int(utils.RefreshTokenTTL.Seconds()),
+		Secure:   false,
+		HTTPOnly: true,
+		SameSite: "strict",
+	})
+}
+
+func clearSessionCookies(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{Name: utils.AccessTokenCookie, Path: "/", MaxAge: -1})
+	c.Cookie(&fiber.Cookie{Name: utils.RefreshTokenCookie, Path: "/api/user", MaxAge: -1})
+}
+
+// accessTokenWindow bounds how long after a session was issued its access
+// token may still be accepted, with some slack for clock skew.
+const accessTokenWindow = utils.AccessTokenTTL + time.Minute
+
+// revokeSessions revokes every refresh token matching filter, together with
+// the access tokens that were issued alongside them.
+func (h *Handler) revokeSessions(ctx context.Context, filter repository.SessionFilter) error {
+	now := time.Now()
+
+	live, err := h.sessions.ListRefreshTokens(ctx, filter, now.Add(-accessTokenWindow))
+	if err != nil {
+		return err
+	}
+
+	revoked := make([]models.RevokedTokens, 0, len(live))
+	for _, record := range live {
+		if record.AccessJti == "" {
+			continue
+		}
+		revoked = append(revoked, models.RevokedTokens{
+			Jti:       record.AccessJti,
+			UserID:    record.UserID,
+			ExpiresAt: record.CreatedAt.Add(accessTokenWindow),
+		})
+	}
+
+	if err := h.sessions.RevokeAccessTokens(ctx, revoked...); err != nil {
+		return err
+	}
+
+	return h.sessions.RevokeRefreshTokens(ctx, filter, now)
+}
+
+func (h *Handler) revokeTokenFamily(ctx context.Context, familyID string) error {
+	return h.revokeSessions(ctx, repository.SessionFilter{FamilyID: familyID})
+}
+
+func (h *Handler) revokeUserSessions(ctx context.Context, userID uint) error {
+	return h.revokeSessions(ctx, repository.SessionFilter{UserID: userID})
+}
+
+// revokeUserAccess revokes the sessions and API keys of a user whose
+// password was replaced, whoever held the old one loses everything it
+// gave them.
+func (h *Handler) revokeUserAccess(ctx context.Context, userID uint) error {
+	if err := h.revokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return h.apiKeys.RevokeByUser(ctx, userID, time.Now())
+}
+
+func (h *Handler) RefreshToken(c *fiber.Ctx) error {
+	raw := c.Cookies(utils.RefreshTokenCookie)
+	if raw == "" {
+		return apperr.BadRequest("Missing refresh token")
+	}
+
+	current, err := h.sessions.FindRefreshToken(c.UserContext(), utils.HashToken(raw))
+	if err != nil {
+		return apperr.Unauthorized("Invalid refresh token")
+	}
+
+	if current.RevokedAt != nil {
+		return h.refreshTokenReused(c, current)
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		clearSessionCookies(c)
+		return apperr.Unauthorized("Refresh token expired")
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), current.UserID)
+	if err != nil {
+		clearSessionCookies(c)
+		return apperr.Unauthorized("Invalid refresh token")
+	}
+
+	accessToken, next, refreshToken, err := h.newSession(user, current.FamilyID)
+	if err == nil {
+		err = h.sessions.RotateRefreshToken(c.UserContext(), current, &next)
+	}
+
+	if errors.Is(err, repository.ErrTokenReused) {
+		return h.refreshTokenReused(c, current)
+	}
+	if err != nil {
+		return apperr.Internal("Failed to rotate refresh token", err)
+	}
+
+	setSessionCookies(c, accessToken, refreshToken)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Token refreshed",
+		"data":    accessToken,
+	})
+}
+
+func (h *Handler) refreshTokenReused(c *fiber.Ctx, token models.RefreshTokens) error {
+	slog.WarnContext(c.UserContext(), "Refresh token reuse detected, revoking family", "user_id", token.UserID, "family_id", token.FamilyID)
+	if err := h.revokeTokenFamily(c.UserContext(), token.FamilyID); err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to revoke token family", "family_id", token.FamilyID, "error", err)
+	}
+
+	clearSessionCookies(c)
+
+	return apperr.Unauthorized("Refresh token reuse detected, please login again")
+}
+
+func (h *Handler) Logout(c *fiber.Ctx) error {
+	userId, err := utils.GetUserIDFromToken(c)
+	if err != nil {
+		return apperr.Internal("Failed to format userId", err)
+	}
+
+	revoked := models.RevokedTokens{
+		Jti:       utils.GetTokenIDFromToken(c),
+		UserID:    userId,
+		ExpiresAt: utils.GetTokenExpiry(c),
+	}
+
+	if err := h.sessions.RevokeAccessTokens(c.UserContext(), revoked); err != nil {
+		return apperr.Internal("Failed to revoke access token", err)
+	}
+
+	if raw := c.Cookies(utils.RefreshTokenCookie); raw != "" {
+		current, err := h.sessions.FindRefreshToken(c.UserContext(), utils.HashToken(raw))
+		if err == nil && current.UserID == userId {
+			if err := h.revokeTokenFamily(c.UserContext(), current.FamilyID); err != nil {
+				slog.ErrorContext(c.UserContext(), "Failed to revoke token family", "family_id", current.FamilyID, "error", err)
+			}
+		}
+	}
+
+	clearSessionCookies(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Logout success",
+	})
+}
@@ -1,165 +1,138 @@
 package handler
 
 import (
-	"fmt"
-	"go-task/database"
+	"context"
+	"errors"
+	"go-task/apperr"
 	"go-task/models"
-	"go-task/utils"
-	"log"
-	"strings"
+	"go-task/repository"
+	"log/slog"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func RegisterUser(c *fiber.Ctx) error {
-	type RegisterUserInput struct {
-		Username  string `json:"username" validate:"required,min=3,max=32"`
-		Email     string `json:"email" validate:"required,email"`
-		Password  string `json:"password" validate:"required,min=8,max=16"`
-		FirstName string `json:"firstName" validate:"required,min=3,max=8"`
-		LastName  string `json:"lastName" `
-		Role      string `json:"role" validate:"required,oneof=admin user"`
-	}
+type RegisterUserInput struct {
+	Username  string `json:"username" validate:"required,min=3,max=32,username,unique=users.username"`
+	Email     string `json:"email" validate:"required,email,unique=users.email"`
+	Password  string `json:"password" validate:"required,min=8,max=16,password"`
+	FirstName string `json:"firstName" validate:"required,min=3,max=8"`
+	LastName  string `json:"lastName" `
+}
 
+func (h *Handler) RegisterUser(c *fiber.Ctx) error {
 	var input RegisterUserInput
 
 	if err := c.BodyParser(&input); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid request body",
-		})
+		return apperr.BadRequest("Invalid request body")
 	}
 
-	log.Printf("Incoming input: %+v", input)
-
-	if errs := utils.ValidationHandler(input); errs != nil {
+	if errs := h.validate(c, input); errs != nil {
 		return errs
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server errror",
-		})
+		return apperr.Internal("Failed to hash password", err)
 	}
 
+	// Roles are only granted by admins, or by the assign-role command for
+	// the first one.
+	role := models.User
 	user := models.Users{
 		Username:  input.Username,
 		Email:     input.Email,
 		Password:  string(hashedPassword),
 		FirstName: input.FirstName,
 		LastName:  &input.LastName,
-		Role:      (*models.Role)(&input.Role),
-	}
-
-	if err := database.DB.Create(&user).Error; err != nil {
-		fmt.Println("Error creating user:", err.Error())
-
-		// Check for unique constraint violation
-		if pqErr, ok := err.(interface{ Error() string }); ok {
-			errMsg := pqErr.Error()
-			if strings.Contains(errMsg, "uni_users_email") {
-				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-					"success": false,
-					"message": "Email already registered",
-				})
-			} else if strings.Contains(errMsg, "uni_users_username") {
-				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-					"success": false,
-					"message": "Username already taken",
-				})
-			}
-		}
+		Role:      &role,
+	}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to create user",
-		})
+	err = h.users.Create(c.UserContext(), &user)
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		return apperr.Conflict("Email already registered")
 	}
+	if errors.Is(err, repository.ErrDuplicateUsername) {
+		return apperr.Conflict("Username already taken")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to create user", err)
+	}
+
+	h.metrics.Registrations.Inc()
+
+	h.outbox.Go(c.UserContext(), func(ctx context.Context) {
+		h.sendVerification(ctx, user)
+	})
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
-		"message": "User registered.",
+		"message": "User registered, check your email to verify your address.",
 	})
 }
 
-func LoginUser(c *fiber.Ctx) error {
-	type LoginInput struct {
-		Username string `json:"username" validate:"max=32"`
-		Email    string `json:"email" validate:"max=32"`
-		Password string `json:"password" validate:"required,min=8,max=32"`
-	}
+type LoginInput struct {
+	Username string `json:"username" validate:"max=32"`
+	Email    string `json:"email" validate:"max=32"`
+	Password string `json:"password" validate:"required,min=8,max=32"`
+}
 
+func (h *Handler) LoginUser(c *fiber.Ctx) error {
 	var input LoginInput
 
 	if err := c.BodyParser(&input); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid body request",
-		})
+		return apperr.BadRequest("Invalid body request")
 	}
 
-	log.Printf("Incoming input: %+v", input)
-
-	if errs := utils.ValidationHandler(input); errs != nil {
+	if errs := h.validate(c, input); errs != nil {
 		return errs
 	}
 
 	var user models.Users
-	query := database.DB
+	var err error
 	if input.Username != "" {
-		query = query.Where("username = ?", input.Username)
+		user, err = h.users.FindByUsername(c.UserContext(), input.Username)
 	} else if input.Email != "" {
-		query = query.Where("email = ?", input.Email)
+		user, err = h.users.FindByEmail(c.UserContext(), input.Email)
 	} else {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Login must be include one of credential email or username",
-		})
+		return apperr.BadRequest("Login must be include one of credential email or username")
+	}
+
+	if err != nil {
+		h.metrics.FailedLogins.WithLabelValues("unknown_user").Inc()
+		return apperr.Unauthorized("Invalid credentials")
 	}
 
-	if err := query.First(&user).Error; err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid credentials",
-		})
+	// A locked account is refused before the password is checked, so
+	// guessing cannot go on while it is locked.
+	if err := h.accountLocked(c, user); err != nil {
+		return err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid credentials",
-		})
+		h.metrics.FailedLogins.WithLabelValues("bad_password").Inc()
+		h.recordLoginFailure(c.UserContext(), user)
+		return apperr.Unauthorized("Invalid credentials")
 	}
 
-	credential := utils.JwtCredentialStruct{
-		Id:       user.Id,
-		Username: user.Username,
-		Role:     *user.Role,
+	// The failures are only reset once the second factor is in as well,
+	// otherwise the password would allow guessing codes without end.
+	if user.MFAEnabledAt != nil {
+		return h.mfaChallenge(c, user)
 	}
 
-	token := utils.CreateJWT(credential)
-	if token == "" {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Internal server error",
-		})
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.users.ResetLoginFailures(c.UserContext(), user.Id); err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to reset failed logins", "user_id", user.Id, "error", err)
+		}
 	}
 
-	cookieOpts := fiber.Cookie{
-		Name:     "_token",
-		Value:    token,
-		Path:     "/",
-		MaxAge:   int(utils.JwtExpire),
-		Secure:   false,
-		HTTPOnly: true,
-		SameSite: "lax",
+	token, err := h.openSession(c, user)
+	if err != nil {
+		return apperr.Internal("Failed to create session", err)
 	}
-
-	c.Cookie(&cookieOpts)
+	h.metrics.Logins.Inc()
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"success": true,
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-task/apperr"
+	"go-task/mail"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// verificationPurpose keeps verification links from being accepted as any
+// other signed token.
+const verificationPurpose = "email-verification"
+
+// withToken returns base with token added as the token query parameter.
+func withToken(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// sendVerification emails user a signed link to VerifyEmail. The link names
+// the address it was sent to, so it stops working when the email changes.
+func (h *Handler) sendVerification(ctx context.Context, user models.Users) {
+	ttl := time.Duration(h.verification.TokenTTL)
+	token := utils.SignToken(h.jwtSecret, verificationPurpose,
+		strconv.FormatUint(uint64(user.Id), 10)+":"+user.Email, time.Now().Add(ttl))
+
+	link, err := withToken(h.verification.URL, token)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid email verification URL", "error", err)
+		return
+	}
+
+	err = h.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to confirm this is your email address. It expires in %s.\n\n%s\n\n"+
+			"If you did not create an account you can ignore this email.\n", user.FirstName, ttl, link),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send verification email", "user_id", user.Id, "error", err)
+	}
+}
+
+// VerifyEmail marks the address of a user as verified with the token of a
+// link from sendVerification.
+func (h *Handler) VerifyEmail(c *fiber.Ctx) error {
+	invalid := apperr.BadRequest("Invalid or expired verification link")
+
+	payload, err := utils.VerifySignedToken(h.jwtSecret, verificationPurpose, c.Query("token"))
+	if err != nil {
+		return invalid
+	}
+
+	rawID, email, _ := strings.Cut(payload, ":")
+	userId, err := strconv.ParseUint(rawID, 10, 32)
+	if err != nil {
+		return invalid
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), uint(userId))
+	if errors.Is(err, repository.ErrNotFound) {
+		return invalid
+	}
+	if err != nil {
+		return apperr.Internal("Failed to verify email", err)
+	}
+	if user.Email != email {
+		return invalid
+	}
+
+	if user.VerifiedAt == nil {
+		if err := h.users.MarkVerified(c.UserContext(), user.Id, time.Now()); err != nil {
+			return apperr.Internal("Failed to verify email", err)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Email verified.",
+	})
+}
+
+// ResendVerification emails the current user a new verification link.
+func (h *Handler) ResendVerification(c *fiber.Ctx) error {
+	userId, err := utils.GetUserIDFromToken(c)
+	if err != nil {
+		return apperr.Internal("Failed to format userId", err)
+	}
+
+	user, err := h.users.FindByID(c.UserContext(), userId)
+	if err != nil {
+		return apperr.Internal("Failed to retrieve user", err)
+	}
+	if user.VerifiedAt != nil {
+		return apperr.Conflict("Email already verified.")
+	}
+
+	h.outbox.Go(c.UserContext(), func(ctx context.Context) {
+		h.sendVerification(ctx, user)
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success": true,
+		"message": "Verification email sent.",
+	})
+}
@@ -0,0 +1,63 @@
+// Package instrument holds what the logging, metrics and tracing
+// instrumentation share: hooking into every query GORM runs and reading
+// the outcome of a request.
+package instrument
+
+import (
+	"go-task/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RegisterGorm registers before and after around every GORM operation, as
+// the callbacks name:before_<operation> and name:after_<operation>.
+func RegisterGorm(db *gorm.DB, name string, before, after func(operation string) func(*gorm.DB)) error {
+	cb := db.Callback()
+
+	processors := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+
+	for _, processor := range processors {
+		if err := processor.before(name+":before_"+processor.operation, before(processor.operation)); err != nil {
+			return err
+		}
+		if err := processor.after(name+":after_"+processor.operation, after(processor.operation)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status returns the status of the response to c, where err is what the
+// handlers returned. Middleware sees err before the error handler has
+// written the response for it.
+func Status(c *fiber.Ctx, err error) int {
+	if err != nil {
+		return apperr.Status(err)
+	}
+	return c.Response().StatusCode()
+}
+
+// Route returns the template of the route that served c, such as
+// /api/products/:id, and false when no route matched. self is the route of
+// the calling middleware taken before c.Next: Fiber leaves the last route
+// that ran in the context, which is the middleware itself when nothing
+// else matched.
+func Route(c *fiber.Ctx, self *fiber.Route) (string, bool) {
+	if r := c.Route(); r != self {
+		return r.Path, true
+	}
+	return "", false
+}
@@ -0,0 +1,327 @@
+// Package keyset holds the keys access tokens are signed and verified with.
+// Keys are stored in the database so every instance shares them, and rotate
+// on a schedule: a new key is published for the overlap window before it
+// starts signing, and an old one stays valid for the overlap window after
+// it stopped, so neither tokens nor cached key sets ever refer to a key the
+// other side does not know yet or anymore.
+package keyset
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-task/config"
+	"go-task/models"
+	"go-task/repository"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rotationCheckInterval is how often Run checks whether the next key is
+// due and picks up keys created by other instances.
+const rotationCheckInterval = time.Minute
+
+// reloadInterval limits how often a token with an unknown kid makes the
+// keys be read again.
+const reloadInterval = 10 * time.Second
+
+// rsaKeyBits is the size of new RS256 keys.
+const rsaKeyBits = 2048
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+type key struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	activates time.Time
+	expires   time.Time
+}
+
+// Keyset signs access tokens with the active key and verifies them with
+// any key that has not expired. With the HS256 algorithm it signs with the
+// shared secret instead and holds no keys.
+type Keyset struct {
+	cfg    config.JWT
+	secret []byte
+	repo   repository.SigningKeyRepository
+
+	mu     sync.RWMutex
+	keys   []key
+	loaded time.Time
+}
+
+func New(cfg config.JWT, repo repository.SigningKeyRepository) *Keyset {
+	return &Keyset{cfg: cfg, secret: []byte(cfg.Secret), repo: repo}
+}
+
+func (k *Keyset) symmetric() bool {
+	return k.cfg.Algorithm == config.JWTAlgorithmHS256
+}
+
+// Rotate creates the next key once it is due and reloads the keys. The
+// next key is due one overlap window before the active one has signed for
+// a rotation interval.
+func (k *Keyset) Rotate(ctx context.Context) error {
+	if k.symmetric() {
+		return nil
+	}
+
+	now := time.Now()
+	keys, err := k.load(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(k.cfg.RotationInterval)
+	overlap := time.Duration(k.cfg.RotationOverlap)
+
+	var latest *key
+	for i := range keys {
+		if keys[i].method.Alg() == k.cfg.Algorithm {
+			latest = &keys[i]
+		}
+	}
+
+	activates := now
+	if latest != nil {
+		next := latest.activates.Add(interval)
+		if now.Before(next.Add(-overlap)) {
+			k.store(keys, now)
+			return nil
+		}
+		if next.After(now) {
+			activates = next
+		}
+	}
+
+	created, err := k.create(ctx, activates, activates.Add(interval+overlap))
+	if err != nil {
+		return fmt.Errorf("create signing key: %w", err)
+	}
+	slog.InfoContext(ctx, "Signing key created", "kid", created.kid, "algorithm", k.cfg.Algorithm, "activates_at", activates)
+
+	k.store(append(keys, created), now)
+	return nil
+}
+
+// Run rotates the keys until ctx is done. Failures are logged, the active
+// key keeps signing until it expires.
+func (k *Keyset) Run(ctx context.Context) error {
+	if k.symmetric() {
+		return nil
+	}
+
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := k.Rotate(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to rotate signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// load reads the keys valid at now. Keys that cannot be decrypted, because
+// the JWT secret changed, are skipped so new ones replace them.
+func (k *Keyset) load(ctx context.Context, now time.Time) ([]key, error) {
+	records, err := k.repo.ListValid(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("load signing keys: %w", err)
+	}
+
+	keys := make([]key, 0, len(records))
+	for _, record := range records {
+		parsed, err := k.parse(record)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unreadable signing key", "kid", record.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, parsed)
+	}
+	return keys, nil
+}
+
+func (k *Keyset) store(keys []key, at time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = keys
+	k.loaded = at
+}
+
+func (k *Keyset) parse(record models.SigningKeys) (key, error) {
+	method := jwt.GetSigningMethod(record.Algorithm)
+	if method == nil {
+		return key{}, fmt.Errorf("unknown algorithm %q", record.Algorithm)
+	}
+
+	der, err := decrypt(k.secret, record.PrivateKey)
+	if err != nil {
+		return key{}, err
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return key{}, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return key{}, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	return key{
+		kid:       record.Kid,
+		method:    method,
+		private:   signer,
+		activates: record.ActivatesAt,
+		expires:   record.ExpiresAt,
+	}, nil
+}
+
+func (k *Keyset) create(ctx context.Context, activates, expires time.Time) (key, error) {
+	var private crypto.Signer
+	var err error
+	switch k.cfg.Algorithm {
+	case config.JWTAlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case config.JWTAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		err = fmt.Errorf("unsupported algorithm %q", k.cfg.Algorithm)
+	}
+	if err != nil {
+		return key{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return key{}, err
+	}
+	encrypted, err := encrypt(k.secret, der)
+	if err != nil {
+		return key{}, err
+	}
+
+	created := key{
+		method:    jwt.GetSigningMethod(k.cfg.Algorithm),
+		private:   private,
+		activates: activates,
+		expires:   expires,
+	}
+	created.kid = thumbprint(publicJWK(created))
+
+	record := models.SigningKeys{
+		Kid:         created.kid,
+		Algorithm:   k.cfg.Algorithm,
+		PrivateKey:  encrypted,
+		ActivatesAt: activates,
+		ExpiresAt:   expires,
+	}
+	if err := k.repo.Create(ctx, &record); err != nil {
+		return key{}, err
+	}
+	return created, nil
+}
+
+// Sign signs claims with the key that activated last, or with the shared
+// secret when the algorithm is HS256.
+func (k *Keyset) Sign(claims jwt.Claims) (string, error) {
+	if k.symmetric() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+
+	now := time.Now()
+	k.mu.RLock()
+	var active *key
+	for i := range k.keys {
+		if k.keys[i].method.Alg() == k.cfg.Algorithm && !k.keys[i].activates.After(now) {
+			active = &k.keys[i]
+		}
+	}
+	k.mu.RUnlock()
+
+	// Tokens of an expired key could not be verified anymore.
+	if active == nil || !active.expires.After(now) {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.kid
+	return token.SignedString(active.private)
+}
+
+// Keyfunc returns the key to verify token with, for jwt.Parse. The
+// algorithm of the token has to match the one of its key, and HS256 tokens
+// are only accepted with the HS256 algorithm or AcceptHS256.
+func (k *Keyset) Keyfunc(token *jwt.Token) (any, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if k.symmetric() || k.cfg.AcceptHS256 {
+			return k.secret, nil
+		}
+		return nil, errors.New("HS256 tokens are not accepted")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	found, ok := k.find(kid)
+	if !ok && k.reload() {
+		found, ok = k.find(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if found.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("signing key %q is not for %s", kid, token.Method.Alg())
+	}
+	return found.private.Public(), nil
+}
+
+func (k *Keyset) find(kid string) (key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	for _, candidate := range k.keys {
+		if candidate.kid == kid && candidate.expires.After(now) {
+			return candidate, true
+		}
+	}
+	return key{}, false
+}
+
+// reload reads the keys again, for keys another instance created since
+// the last rotation check. It reports whether it did.
+func (k *Keyset) reload() bool {
+	if k.symmetric() {
+		return false
+	}
+
+	now := time.Now()
+	k.mu.Lock()
+	if now.Sub(k.loaded) < reloadInterval {
+		k.mu.Unlock()
+		return false
+	}
+	k.loaded = now
+	k.mu.Unlock()
+
+	keys, err := k.load(context.Background(), now)
+	if err != nil {
+		slog.Error("Failed to reload signing keys", "error", err)
+		return false
+	}
+	k.store(keys, now)
+	return true
+}
@@ -0,0 +1,145 @@
+// Package lifecycle starts the parts of the application in order and stops
+// them in reverse order once the process receives SIGINT or SIGTERM.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Hook is a component managed by the Manager. Start must return once the
+// component is up, long running work belongs in a goroutine that reports
+// failures through Manager.Fail. Either function may be nil.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+type Manager struct {
+	timeout      time.Duration
+	hooks        []Hook
+	failed       chan error
+	shuttingDown atomic.Bool
+}
+
+// New returns a Manager that gives the components timeout in total to stop.
+func New(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout, failed: make(chan error, 1)}
+}
+
+// Append registers a hook. Hooks start in the order they were appended.
+func (m *Manager) Append(hook Hook) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// Go registers a background worker. fn runs from startup until its context
+// is cancelled during shutdown, and an early error shuts the process down.
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	var cancel context.CancelFunc
+	var wg sync.WaitGroup
+
+	m.Append(Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := fn(ctx); err != nil && ctx.Err() == nil {
+					m.Fail(fmt.Errorf("%s: %w", name, err))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+}
+
+// Fail reports that a running component broke and starts the shutdown.
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// ShuttingDown reports whether the shutdown has begun.
+func (m *Manager) ShuttingDown() bool {
+	return m.shuttingDown.Load()
+}
+
+// Run starts every hook, waits until ctx is done, a termination signal
+// arrives or a component fails, and then stops the started hooks in
+// reverse order.
+func (m *Manager) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	started := 0
+	var runErr error
+	for _, hook := range m.hooks {
+		if hook.Start != nil {
+			if err := hook.Start(ctx); err != nil {
+				runErr = fmt.Errorf("start %s: %w", hook.Name, err)
+				break
+			}
+		}
+		started++
+	}
+
+	if runErr == nil {
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+		case runErr = <-m.failed:
+			slog.Error("Shutting down after failure", "error", runErr)
+		}
+	}
+
+	return errors.Join(runErr, m.shutdown(started))
+}
+
+func (m *Manager) shutdown(started int) error {
+	m.shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var errs []error
+	for i := started - 1; i >= 0; i-- {
+		hook := m.hooks[i]
+		if hook.Stop == nil {
+			continue
+		}
+		if err := hook.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", hook.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"go-task/instrument"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// Middleware gives every request an ID, reusing a well-formed one sent by
+// the client or a proxy. The ID is returned in the response header and
+// attached to the user context, so records logged with that context carry
+// it. Each request is logged once it completes.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		started := time.Now()
+
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDHeader, id)
+
+		ctx := WithRequestID(c.UserContext(), id)
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		status := instrument.Status(c, err)
+		attrs := []any{
+			"method", c.Method(),
+			"path", c.Path(),
+			"ip", c.IP(),
+		}
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs = append(attrs, "status", status, "duration_ms", time.Since(started).Milliseconds())
+
+		slog.Log(ctx, level, "request", attrs...)
+
+		return err
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
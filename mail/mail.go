@@ -0,0 +1,180 @@
+// Package mail delivers the emails the application sends, such as password
+// reset links. New picks the Mailer configured in config.Mail.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go-task/config"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer cfg selects.
+func New(cfg config.Mail) (Mailer, error) {
+	switch cfg.Driver {
+	case config.MailSMTP:
+		if cfg.SMTP.Host == "" {
+			return nil, errors.New("mail: SMTP_HOST is required by the smtp driver")
+		}
+		return NewSMTP(cfg.From, cfg.SMTP, nil), nil
+	case config.MailFile:
+		return NewFile(cfg.From, cfg.File), nil
+	case config.MailLog:
+		return NewLog(), nil
+	}
+	return nil, fmt.Errorf("mail: unknown driver %q", cfg.Driver)
+}
+
+// format renders msg with the headers of RFC 5322.
+func format(from string, msg Message, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// SMTPMailer sends messages through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	from string
+	cfg  config.SMTP
+	tls  *tls.Config
+}
+
+// NewSMTP returns a mailer for the server in cfg. The certificate of the
+// server is verified for cfg.Host, against the system roots unless
+// tlsConfig sets others.
+func NewSMTP(from string, cfg config.SMTP, tlsConfig *tls.Config) *SMTPMailer {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	tlsConfig.ServerName = cfg.Host
+
+	return &SMTPMailer{from: from, cfg: cfg, tls: tlsConfig}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(m.tls); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		auth := smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(format(m.from, msg, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileMailer appends messages to a file in mbox format, to read the mail
+// of a local instance without a mail server.
+type FileMailer struct {
+	mu   sync.Mutex
+	from string
+	path string
+}
+
+func NewFile(from, path string) *FileMailer {
+	return &FileMailer{from: from, path: path}
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = fmt.Fprintf(f, "From %s %s\n%s\n", m.from, now.Format(time.ANSIC),
+		strings.ReplaceAll(string(format(m.from, msg, now)), "\r\n", "\n"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LogMailer only logs the recipient and subject of messages instead of
+// sending them. Their bodies carry links with secret tokens and are left
+// out, use the file driver to read them locally.
+type LogMailer struct{}
+
+func NewLog() LogMailer {
+	return LogMailer{}
+}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Email not sent", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SendTimeout bounds how long sending a single email may take.
+const SendTimeout = 30 * time.Second
+
+// Outbox sends emails in the background, so requests do not wait for the
+// mail server, and lets the shutdown wait for what is still being sent.
+type Outbox struct {
+	timeout time.Duration
+	wg      sync.WaitGroup
+}
+
+// NewOutbox returns an Outbox that gives every delivery timeout to finish.
+func NewOutbox(timeout time.Duration) *Outbox {
+	return &Outbox{timeout: timeout}
+}
+
+// Go runs deliver in the background. Its context keeps the values of ctx
+// but not its cancellation, since the request is answered before the email
+// is sent.
+func (o *Outbox) Go(ctx context.Context, deliver func(ctx context.Context)) {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.timeout)
+		defer cancel()
+		deliver(ctx)
+	}()
+}
+
+// Wait blocks until every delivery started so far has finished, or until
+// ctx is done.
+func (o *Outbox) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
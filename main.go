@@ -1,43 +1,170 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"go-task/apperr"
+	"go-task/config"
 	"go-task/database"
+	"go-task/handler"
+	"go-task/keyset"
+	"go-task/lifecycle"
+	"go-task/logging"
+	"go-task/mail"
+	"go-task/metrics"
+	"go-task/repository"
 	"go-task/routes"
-	"go-task/utils"
+	"go-task/tracing"
+	"log/slog"
+	"net"
+	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/joho/godotenv"
 )
 
-type User struct {
-	Name string `validate:"required,min=5,max=20"` // Required field, min 5 char long max 20
-	Age  int    `validate:"required"`              // Required field, and client needs to implement our 'teener' tag format which we'll see later
-}
-
 func main() {
 
-	if err := godotenv.Load(); err != nil {
-		panic("Failed to load .env file")
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	slog.SetDefault(logging.New(cfg.Log))
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "assign-role" {
+		if err := runAssignRole(cfg, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	app := fiber.New(fiber.Config{
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return c.Status(fiber.StatusBadRequest).JSON(utils.GlobalErrorHandlerResp{
-				Success: true,
-				Message: err.Error(),
-			})
-		},
+		ErrorHandler: apperr.Handler,
 	})
 
 	app.Use(cors.New())
 
-	database.ConnectDB()
+	lc := lifecycle.New(time.Duration(cfg.ShutdownTimeout))
+	m := metrics.New()
+
+	var stopTracing func(context.Context) error
+	lc.Append(lifecycle.Hook{
+		Name: "tracing",
+		Start: func(ctx context.Context) (err error) {
+			stopTracing, err = tracing.Setup(ctx, cfg.Tracing)
+			return err
+		},
+		// Flushes the spans of the requests drained by the http hook.
+		Stop: func(ctx context.Context) error { return stopTracing(ctx) },
+	})
+
+	var repos repository.Repositories
+	lc.Append(lifecycle.Hook{
+		Name: "database",
+		Start: func(context.Context) error {
+			if err := database.Connect(cfg.Database); err != nil {
+				return err
+			}
+
+			repos = repository.NewGorm(database.DB)
+			if cfg.RateLimit.Store == config.RateLimitStoreMemory {
+				repos.RateLimits = repository.NewMemoryRateLimits()
+			}
 
-	routes.SetupRoutes(app)
+			if err := database.DB.Use(m.GormPlugin()); err != nil {
+				return err
+			}
+			if err := database.DB.Use(tracing.GormPlugin()); err != nil {
+				return err
+			}
+			sqlDB, err := database.DB.DB()
+			if err != nil {
+				return err
+			}
+			return m.WatchDB(sqlDB, cfg.Database.Driver)
+		},
+		Stop: func(context.Context) error { return database.Close() },
+	})
+
+	// The first key is created before the server starts, so logins can be
+	// signed from the first request on.
+	var keys *keyset.Keyset
+	lc.Append(lifecycle.Hook{
+		Name: "signing keys",
+		Start: func(ctx context.Context) error {
+			keys = keyset.New(cfg.JWT, repos.SigningKeys)
+			return keys.Rotate(ctx)
+		},
+	})
+
+	lc.Go("key rotation", func(ctx context.Context) error {
+		return keys.Run(ctx)
+	})
+
+	lc.Go("cleanup", func(ctx context.Context) error {
+		return purgeExpired(ctx, repos, time.Hour)
+	})
+
+	outbox := mail.NewOutbox(mail.SendTimeout)
+	lc.Append(lifecycle.Hook{
+		Name: "http",
+		Start: func(context.Context) error {
+			routes.SetupRoutes(app, handler.Dependencies{
+				Config:    cfg,
+				Repos:     repos,
+				Lifecycle: lc,
+				Metrics:   m,
+				Mailer:    mailer,
+				Outbox:    outbox,
+				Keys:      keys,
+			})
+
+			ln, err := net.Listen("tcp", cfg.Addr())
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				if err := app.Listener(ln); err != nil {
+					lc.Fail(err)
+				}
+			}()
+			return nil
+		},
+		// Stops accepting connections and waits for in-flight requests,
+		// then for the emails they started sending.
+		Stop: func(ctx context.Context) error {
+			select {
+			case <-time.After(time.Duration(cfg.ShutdownDelay)):
+			case <-ctx.Done():
+			}
+			if err := app.ShutdownWithContext(ctx); err != nil {
+				return err
+			}
+			return outbox.Wait(ctx)
+		},
+	})
 
-	const PORT = ":3000"
-	if err := app.Listen(PORT); err != nil {
-		panic(err)
+	if err := lc.Run(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"errors"
+	"go-task/instrument"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const startedKey = "metrics:started"
+
+type gormPlugin struct {
+	m *Metrics
+}
+
+// GormPlugin times every query GORM runs. Install it with db.Use.
+func (m *Metrics) GormPlugin() gorm.Plugin {
+	return gormPlugin{m: m}
+}
+
+func (p gormPlugin) Name() string {
+	return "metrics"
+}
+
+func (p gormPlugin) Initialize(db *gorm.DB) error {
+	return instrument.RegisterGorm(db, p.Name(), p.start, p.finish)
+}
+
+func (p gormPlugin) start(string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(startedKey, time.Now())
+	}
+}
+
+func (p gormPlugin) finish(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startedKey)
+		if !ok {
+			return
+		}
+
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+
+		p.m.dbDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			p.m.dbErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package metrics collects the Prometheus metrics of the service: HTTP
+// traffic per route template, GORM query timings, connection pool stats and
+// business events.
+package metrics
+
+import (
+	"database/sql"
+	"go-task/instrument"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	dbDuration   *prometheus.HistogramVec
+	dbErrors     *prometheus.CounterVec
+
+	Registrations   prometheus.Counter
+	Logins          prometheus.Counter
+	FailedLogins    *prometheus.CounterVec
+	ProductsCreated prometheus.Counter
+}
+
+// New creates the metrics on their own registry, together with the Go
+// runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route template.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Database query latency by operation and table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "table"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Failed database queries by operation and table.",
+		}, []string{"operation", "table"}),
+		Registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "user_registrations_total",
+			Help: "Users registered.",
+		}),
+		Logins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "user_logins_total",
+			Help: "Successful logins.",
+		}),
+		FailedLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "user_login_failures_total",
+			Help: "Failed logins by reason.",
+		}, []string{"reason"}),
+		ProductsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "products_created_total",
+			Help: "Products created.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.dbDuration,
+		m.dbErrors,
+		m.Registrations,
+		m.Logins,
+		m.FailedLogins,
+		m.ProductsCreated,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records every request under its route template, such as
+// /api/products/:id, so IDs do not blow up the label cardinality. Requests
+// that match no route are recorded as "unmatched".
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		started := time.Now()
+		self := c.Route()
+		err := c.Next()
+
+		status := instrument.Status(c, err)
+		route, ok := instrument.Route(c, self)
+		if !ok {
+			route = "unmatched"
+		}
+
+		method := utils.CopyString(c.Method())
+		m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(method, route).Observe(time.Since(started).Seconds())
+
+		return err
+	}
+}
+
+// WatchDB exposes the connection pool stats of db.
+func (m *Metrics) WatchDB(db *sql.DB, name string) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"errors"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiKeyTouchInterval limits how often the last use of a key is written, a
+// busy script would otherwise cause a write per request.
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyFrom returns the API key sent with the request, if any. Bearer
+// tokens only count as API keys when they carry the key prefix.
+func apiKeyFrom(c *fiber.Ctx) string {
+	if key := c.Get(utils.APIKeyHeader); key != "" {
+		return key
+	}
+
+	scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(token, utils.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// checkAPIKey lets the request act as the owner of the key, with the
+// scopes of the key that the owner's role still grants. Keys of admins
+// count as a second factor, they can only be created in a session opened
+// with one and are revoked when an admin resets it.
+func (a *Auth) checkAPIKey(c *fiber.Ctx, raw string) error {
+	key, err := a.apiKeys.FindByHash(c.UserContext(), utils.HashToken(raw))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && key.RevokedAt != nil) {
+		endVerification(c, errors.New("unknown or revoked API key"))
+		return apperr.Unauthorized("Invalid or revoked API key")
+	}
+	if err != nil {
+		endVerification(c, err)
+		return apperr.Internal("Failed to check API key", err)
+	}
+
+	user, err := a.users.FindByID(c.UserContext(), key.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		endVerification(c, err)
+		return apperr.Unauthorized("Invalid or revoked API key")
+	}
+	if err != nil {
+		endVerification(c, err)
+		return apperr.Internal("Failed to check API key", err)
+	}
+
+	role := models.User
+	if user.Role != nil {
+		role = *user.Role
+	}
+	granted, err := a.roles.Permissions(c.UserContext(), role)
+	if err != nil {
+		endVerification(c, err)
+		return apperr.Internal("Failed to resolve permissions", err)
+	}
+
+	c.Locals("user", utils.APIKeyToken(utils.JwtCredentialStruct{
+		Id:       user.Id,
+		Username: user.Username,
+		Role:     role,
+		MFA:      true,
+	}, key.Id))
+	utils.SetPermissions(c, grantedScopes(key.ScopeList(), granted))
+
+	if now := time.Now(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := a.apiKeys.Touch(c.UserContext(), key.Id, now); err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to record API key use", "api_key_id", key.Id, "error", err)
+		}
+	}
+
+	endVerification(c, nil)
+	return c.Next()
+}
+
+// grantedScopes returns the self scopes and the scopes that are among the
+// granted permissions.
+func grantedScopes(scopes, granted []string) []string {
+	if slices.Contains(granted, models.PermAll) {
+		return scopes
+	}
+
+	result := []string{}
+	for _, scope := range scopes {
+		if models.IsSelfScope(scope) || slices.Contains(granted, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result
+}
+
+// RequireSession refuses requests authenticated with an API key, for routes
+// that manage sessions and credentials. It must run after Protected.
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if utils.GetAPIKeyIDFromToken(c) != 0 {
+			return apperr.Forbidden("API keys cannot be used for this request.")
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireScope refuses requests authenticated with an API key that lacks
+// scope. Sessions pass, for self scopes their user always holds. It must
+// run after Protected.
+func (a *Auth) RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if utils.GetAPIKeyIDFromToken(c) == 0 {
+			return c.Next()
+		}
+
+		ok, err := a.HasPermission(c, scope)
+		if err != nil {
+			return apperr.Internal("Failed to resolve permissions", err)
+		}
+		if !ok {
+			return apperr.Forbidden("The API key lacks the '" + scope + "' scope.")
+		}
+
+		return c.Next()
+	}
+}
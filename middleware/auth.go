@@ -1,31 +1,125 @@
 package middleware
 
 import (
-	"go-task/config"
+	"context"
+	"errors"
+	"go-task/apperr"
+	"go-task/keyset"
+	"go-task/repository"
+	"go-task/tracing"
+	"go-task/utils"
 
 	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Protected() func(*fiber.Ctx) error {
-	secretKey := config.GetEnv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "s3cret"
+// Auth holds what the authentication and authorization middleware need to
+// verify tokens against the keyset and look up revoked tokens, API keys and
+// role permissions.
+type Auth struct {
+	keys     *keyset.Keyset
+	sessions repository.SessionRepository
+	roles    repository.RoleRepository
+	users    repository.UserRepository
+	apiKeys  repository.APIKeyRepository
+}
+
+func NewAuth(keys *keyset.Keyset, repos repository.Repositories) *Auth {
+	return &Auth{
+		keys:     keys,
+		sessions: repos.Sessions,
+		roles:    repos.Roles,
+		users:    repos.Users,
+		apiKeys:  repos.APIKeys,
 	}
-	return jwtware.New(jwtware.Config{
-		SigningKey:   jwtware.SigningKey{Key: []byte(secretKey)},
-		ErrorHandler: jwtError,
-		TokenLookup:  "cookie:_token",
+}
+
+// verification is the span covering the token checks of Protected, kept on
+// the request until the checks are done.
+type verification struct {
+	parent context.Context
+	span   trace.Span
+}
+
+const verificationLocal = "jwtVerification"
+
+// Protected authenticates the request with the access token cookie, or with
+// an API key sent in X-API-Key or as a bearer token.
+func (a *Auth) Protected() func(*fiber.Ctx) error {
+	verify := jwtware.New(jwtware.Config{
+		KeyFunc: a.keys.Keyfunc,
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			endVerification(c, err)
+			return jwtError(err)
+		},
+		SuccessHandler: a.checkRevoked,
+		TokenLookup:    "cookie:_token",
 	})
+
+	return func(c *fiber.Ctx) error {
+		key := apiKeyFrom(c)
+		name := "auth.verify_jwt"
+		if key != "" {
+			name = "auth.verify_api_key"
+		}
+
+		parent := c.UserContext()
+		ctx, span := tracing.Tracer().Start(parent, name)
+		c.SetUserContext(ctx)
+		c.Locals(verificationLocal, verification{parent: parent, span: span})
+
+		if key != "" {
+			return a.checkAPIKey(c, key)
+		}
+		return verify(c)
+	}
 }
 
-func jwtError(c *fiber.Ctx, err error) error {
-	if err.Error() == "Missing or malformed JWT" {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{"success": false, "message": "Missing or malformed JWT"})
+// endVerification ends the verification span, failed when err is not nil,
+// and restores the request context so later spans are not nested in it.
+func endVerification(c *fiber.Ctx, err error) {
+	v, ok := c.Locals(verificationLocal).(verification)
+	if !ok {
+		return
+	}
+	c.Locals(verificationLocal, nil)
 
-	} else {
-		c.Status(fiber.StatusUnauthorized)
-		return c.JSON(fiber.Map{"success": false, "message": "Invalid or expired JWT"})
+	if err != nil {
+		v.span.SetStatus(codes.Error, err.Error())
 	}
+	v.span.End()
+	c.SetUserContext(v.parent)
+}
+
+func jwtError(err error) error {
+	if errors.Is(err, jwtware.ErrJWTMissingOrMalformed) {
+		return apperr.Unauthorized("Missing or malformed JWT")
+	}
+	return apperr.Unauthorized("Invalid or expired JWT")
+}
+
+// checkRevoked rejects tokens whose jti is on the revocation list. Tokens
+// without a jti cannot be revoked and are refused as well.
+func (a *Auth) checkRevoked(c *fiber.Ctx) error {
+	jti := utils.GetTokenIDFromToken(c)
+	if jti == "" {
+		endVerification(c, errors.New("token without jti"))
+		return apperr.Unauthorized("Invalid or expired JWT")
+	}
+
+	revoked, err := a.sessions.IsAccessTokenRevoked(c.UserContext(), jti)
+	if err != nil {
+		endVerification(c, err)
+		return apperr.Internal("Failed to check token revocation", err)
+	}
+
+	if revoked {
+		endVerification(c, errors.New("token revoked"))
+		return apperr.Unauthorized("Token has been revoked")
+	}
+
+	endVerification(c, nil)
+	return c.Next()
 }
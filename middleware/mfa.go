@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/utils"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireMFA refuses tokens of users with one of roles unless the session
+// was opened with a second factor. It must run after Protected.
+func RequireMFA(roles ...models.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !utils.GetMFAFromToken(c) && slices.Contains(roles, utils.GetRoleFromToken(c)) {
+			return apperr.Forbidden("Set up two-factor authentication to continue.")
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/repository"
+	"go-task/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireOwnership loads the T identified by the route parameter param with
+// load and only lets the request through when the current user owns it or
+// holds anyPermission. Owning is enough for sessions, an API key also needs
+// ownScope unless it is empty. The loaded record is available through
+// utils.GetResource.
+func RequireOwnership[T models.Owned](auth *Auth, load func(ctx context.Context, id uint) (T, error), param, ownScope, anyPermission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params(param), 10, 32)
+		if err != nil {
+			return apperr.BadRequest("Invalid resource ID.")
+		}
+
+		resource, err := load(c.UserContext(), uint(id))
+		if errors.Is(err, repository.ErrNotFound) {
+			return apperr.NotFound("Resource not found.")
+		}
+		if err != nil {
+			return apperr.Internal("Failed to load resource", err)
+		}
+
+		userId, err := utils.GetUserIDFromToken(c)
+		if err != nil {
+			return apperr.Internal("Failed to format userId", err)
+		}
+
+		owner := resource.OwnerID() == userId
+		if owner && ownScope != "" && utils.GetAPIKeyIDFromToken(c) != 0 {
+			if owner, err = auth.HasPermission(c, ownScope); err != nil {
+				return apperr.Internal("Failed to resolve permissions", err)
+			}
+		}
+
+		if !owner {
+			ok, err := auth.HasPermission(c, anyPermission)
+			if err != nil {
+				return apperr.Internal("Failed to resolve permissions", err)
+			}
+
+			if !ok {
+				return apperr.Forbidden("Forbidden access this resource.")
+			}
+		}
+
+		utils.SetResource(c, resource)
+		return c.Next()
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"go-task/apperr"
+	"go-task/config"
+	"go-task/repository"
+	"go-task/utils"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit allows rule.Limit requests per rule.Window for every key the
+// rule selects, counted in store under the given name. Every response
+// carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// refused requests get 429 with Retry-After. Rules keyed by user must run
+// after Protected.
+func RateLimit(store repository.RateLimitRepository, name string, rule config.RateLimitRule) fiber.Handler {
+	if rule.Limit == 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	window := time.Duration(rule.Window)
+
+	return func(c *fiber.Ctx) error {
+		bucket := name + ":" + rateLimitKey(c, rule.Key)
+
+		hits, resetAt, err := store.Hit(c.UserContext(), bucket, window)
+		if err != nil {
+			// An unavailable store must not take the API down with it.
+			slog.ErrorContext(c.UserContext(), "Failed to count request for rate limit", "bucket", name, "error", err)
+			return c.Next()
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(time.Until(resetAt).Seconds())))
+		c.Set("RateLimit-Limit", strconv.Itoa(rule.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(max(rule.Limit-hits, 0)))
+		c.Set("RateLimit-Reset", reset)
+
+		if hits > rule.Limit {
+			c.Set(fiber.HeaderRetryAfter, reset)
+			return apperr.TooManyRequests("Too many requests, try again later.")
+		}
+
+		return c.Next()
+	}
+}
+
+// rateLimitKey identifies who the request counts against. Requests without
+// a username or an authenticated user fall back to the client IP.
+func rateLimitKey(c *fiber.Ctx, key string) string {
+	switch key {
+	case config.RateLimitByUsername:
+		var body struct {
+			Username string `json:"username"`
+			Email    string `json:"email"`
+		}
+		if err := c.BodyParser(&body); err == nil {
+			// Same precedence as LoginUser.
+			name := body.Username
+			if name == "" {
+				name = body.Email
+			}
+			if name != "" {
+				return "username:" + strings.ToLower(name)
+			}
+		}
+	case config.RateLimitByUser:
+		if c.Locals("user") != nil {
+			if id, err := utils.GetUserIDFromToken(c); err == nil {
+				return "user:" + strconv.FormatUint(uint64(id), 10)
+			}
+		}
+	}
+	return "ip:" + c.IP()
+}
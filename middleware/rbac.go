@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permissions returns the permissions granted to the role of the current
+// token. The result is cached on the request so repeated checks only look
+// the role up once. Admins only hold theirs in sessions opened with a
+// second factor, with a password alone they count as users.
+func (a *Auth) Permissions(c *fiber.Ctx) ([]string, error) {
+	if cached, ok := utils.GetPermissions(c); ok {
+		return cached, nil
+	}
+
+	role := utils.GetRoleFromToken(c)
+	if role == models.Admin && !utils.GetMFAFromToken(c) {
+		role = models.User
+	}
+
+	permissions, err := a.roles.Permissions(c.UserContext(), role)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.SetPermissions(c, permissions)
+	return permissions, nil
+}
+
+// ResolvePermissions looks the permissions of the caller up for handlers
+// that act on them, see utils.GetPermissions. It must run after Protected.
+func (a *Auth) ResolvePermissions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := a.Permissions(c); err != nil {
+			return apperr.Internal("Failed to resolve permissions", err)
+		}
+		return c.Next()
+	}
+}
+
+func (a *Auth) HasPermission(c *fiber.Ctx, permission string) (bool, error) {
+	permissions, err := a.Permissions(c)
+	if err != nil {
+		return false, err
+	}
+
+	return models.Grants(permissions, permission), nil
+}
+
+// Require only lets the request through when the role of the authenticated
+// user grants every listed permission. It must run after Protected.
+func (a *Auth) Require(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, permission := range permissions {
+			ok, err := a.HasPermission(c, permission)
+			if err != nil {
+				return apperr.Internal("Failed to resolve permissions", err)
+			}
+
+			if !ok {
+				return apperr.Forbidden("Forbidden access this resource.")
+			}
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"go-task/apperr"
+	"go-task/models"
+	"go-task/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireVerified only lets users through whose email address is verified.
+// It loads the current user with load and must run after Protected.
+func RequireVerified(load func(ctx context.Context, id uint) (models.Users, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := utils.GetUserIDFromToken(c)
+		if err != nil {
+			return apperr.Internal("Failed to format userId", err)
+		}
+
+		user, err := load(c.UserContext(), userId)
+		if err != nil {
+			return apperr.Internal("Failed to load user", err)
+		}
+
+		if user.VerifiedAt == nil {
+			return apperr.Forbidden("Verify your email address first.")
+		}
+
+		return c.Next()
+	}
+}
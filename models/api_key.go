@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// APIKeys are long-lived credentials of a user for scripts and
+// integrations. Only the hash of a key is stored, Prefix is its start so
+// users can tell their keys apart. Scopes are permissions, a key is never
+// granted more than the role of its owner.
+type APIKeys struct {
+	Id         uint           `gorm:"autoIncrement;primaryKey"`
+	UserID     uint           `gorm:"index;not null"`
+	Name       string         `gorm:"not null"`
+	Prefix     string         `gorm:"not null"`
+	KeyHash    string         `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes     []APIKeyScopes `gorm:"foreignKey:APIKeyID;constraint:OnDelete:CASCADE"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type APIKeyScopes struct {
+	Id       uint   `gorm:"autoIncrement;primaryKey"`
+	APIKeyID uint   `gorm:"uniqueIndex:idx_api_key_scope;not null"`
+	Scope    string `gorm:"uniqueIndex:idx_api_key_scope;not null"`
+}
+
+func (k APIKeys) OwnerID() uint {
+	return k.UserID
+}
+
+// ScopeList returns the permissions granted to the key.
+func (k APIKeys) ScopeList() []string {
+	scopes := make([]string, len(k.Scopes))
+	for i, s := range k.Scopes {
+		scopes[i] = s.Scope
+	}
+	return scopes
+}
@@ -0,0 +1,7 @@
+package models
+
+// Owned is implemented by resources that belong to a single user. Owners may
+// always modify their resources, everyone else needs an ":any" permission.
+type Owned interface {
+	OwnerID() uint
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PasswordResetTokens stores the hash of every password reset token emailed
+// to a user. A token is spent once UsedAt is set.
+type PasswordResetTokens struct {
+	Id        uint      `gorm:"autoIncrement;primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -2,12 +2,22 @@ package models
 
 import "time"
 
+// DefaultCurrency is the currency of products created without one.
+const DefaultCurrency = "IDR"
+
 type Products struct {
-	Id        uint `gorm:"autoIncrement"`
-	Name      string
-	Quantity  uint
-	Price     float64
-	UserID    uint
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Id          uint `gorm:"autoIncrement"`
+	Name        string
+	Description string
+	Quantity    uint
+	Price       float64
+	SKU         *string `gorm:"unique"`
+	Currency    string  `gorm:"not null;default:IDR"`
+	UserID      uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (p Products) OwnerID() uint {
+	return p.UserID
 }
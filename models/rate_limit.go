@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RateLimits counts the requests of one rate limit bucket, such as the
+// logins from one IP address, in the window ending at ResetAt.
+type RateLimits struct {
+	Bucket  string    `gorm:"primaryKey"`
+	Hits    int       `gorm:"not null"`
+	ResetAt time.Time `gorm:"index;not null"`
+}
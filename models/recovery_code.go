@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// RecoveryCodes stores the hashes of the one-time codes that stand in for a
+// TOTP code when the authenticator is lost. A code is spent once UsedAt is
+// set.
+type RecoveryCodes struct {
+	Id        uint   `gorm:"autoIncrement;primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
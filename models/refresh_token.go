@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RefreshTokens stores the hash of every refresh token handed out at login.
+// Tokens that are rotated from the same login share a FamilyID so the whole
+// chain can be revoked when an already-used token is presented again.
+// AccessJti is the jti of the access token issued alongside the refresh token
+// so that revoking a session also revokes its live access token.
+type RefreshTokens struct {
+	Id         uint      `gorm:"autoIncrement;primaryKey"`
+	UserID     uint      `gorm:"index;not null"`
+	FamilyID   string    `gorm:"index;not null"`
+	TokenHash  string    `gorm:"uniqueIndex;not null"`
+	AccessJti  string    `gorm:"index"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	RevokedAt  *time.Time
+	ReplacedBy *uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
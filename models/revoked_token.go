@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RevokedTokens is the revocation list checked by middleware.Protected. Rows
+// only need to live until the access token they refer to would have expired.
+type RevokedTokens struct {
+	Jti       string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	PermAll              = "*"
+	PermProductsWrite    = "products:write"
+	PermProductsReadAny  = "products:read:any"
+	PermProductsWriteAny = "products:write:any"
+	PermUsersRead        = "users:read"
+	PermUsersWrite       = "users:write"
+	PermRolesRead        = "roles:read"
+	PermRolesWrite       = "roles:write"
+)
+
+// Permissions lists every permission that can be granted to a role.
+var Permissions = []string{
+	PermProductsReadAny,
+	PermProductsWriteAny,
+	PermUsersRead,
+	PermUsersWrite,
+	PermRolesRead,
+	PermRolesWrite,
+}
+
+// SelfScopes are API key scopes for what every user may do with their own
+// resources. Roles do not grant them, sessions act on ownership alone while
+// a key needs the scope.
+var SelfScopes = []string{
+	PermProductsWrite,
+}
+
+// Roles are referenced by name from Users.Role. Built-in roles are seeded on
+// startup and cannot be deleted.
+type Roles struct {
+	Id          uint   `gorm:"autoIncrement;primaryKey"`
+	Name        string `gorm:"unique;not null"`
+	Description string
+	BuiltIn     bool              `gorm:"not null;default:false"`
+	Permissions []RolePermissions `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type RolePermissions struct {
+	Id         uint   `gorm:"autoIncrement;primaryKey"`
+	RoleID     uint   `gorm:"uniqueIndex:idx_role_permission;not null"`
+	Permission string `gorm:"uniqueIndex:idx_role_permission;not null"`
+}
+
+// BuiltInRoles are created on startup if they do not exist yet.
+var BuiltInRoles = map[Role][]string{
+	Admin:            {PermAll},
+	User:             {},
+	Support:          {PermUsersRead, PermProductsReadAny},
+	InventoryManager: {PermProductsReadAny, PermProductsWriteAny},
+}
+
+func IsKnownPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Grants reports whether granted includes every one of permissions, which
+// PermAll always does.
+func Grants(granted []string, permissions ...string) bool {
+	if slices.Contains(granted, PermAll) {
+		return true
+	}
+	for _, permission := range permissions {
+		if !slices.Contains(granted, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func IsSelfScope(scope string) bool {
+	return slices.Contains(SelfScopes, scope)
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SigningKeys are the keys access tokens are signed with. PrivateKey is
+// encrypted with the JWT secret. A key is published from CreatedAt, signs
+// from ActivatesAt until the next key activates, and verifies tokens until
+// ExpiresAt.
+type SigningKeys struct {
+	Id          uint      `gorm:"autoIncrement;primaryKey"`
+	Kid         string    `gorm:"uniqueIndex;not null"`
+	Algorithm   string    `gorm:"not null"`
+	PrivateKey  string    `gorm:"not null" json:"-"`
+	ActivatesAt time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+}
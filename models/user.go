@@ -5,8 +5,10 @@ import "time"
 type Role string
 
 const (
-	Admin Role = "admin"
-	User  Role = "user"
+	Admin            Role = "admin"
+	User             Role = "user"
+	Support          Role = "support"
+	InventoryManager Role = "inventory-manager"
 )
 
 type Users struct {
@@ -18,6 +20,20 @@ type Users struct {
 	Username  string     `gorm:"unique;not null"`
 	FirstName string     `gorm:"not null"`
 	LastName  *string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	// FailedLogins counts failed logins since the last successful one, and
+	// LockedUntil is set once they reach the lockout threshold.
+	FailedLogins int `gorm:"not null;default:0"`
+	LockedUntil  *time.Time
+	// VerifiedAt is set once the user opened the link emailed on
+	// registration.
+	VerifiedAt *time.Time
+	// TOTPSecret is the secret of the user's authenticator app. It is
+	// stored on enrollment and only asked for once MFAEnabledAt is set by
+	// confirming a first code. TOTPLastStep is the period of the last
+	// accepted code, so no code works twice.
+	TOTPSecret   *string `json:"-"`
+	TOTPLastStep int64   `json:"-" gorm:"not null;default:0"`
+	MFAEnabledAt *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
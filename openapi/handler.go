@@ -0,0 +1,46 @@
+package openapi
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handler serves the document of app as JSON. It is built on the first
+// request, once every route is registered.
+func Handler(app *fiber.App, docs *Docs, info Info) fiber.Handler {
+	var (
+		once sync.Once
+		doc  *Document
+	)
+	return func(c *fiber.Ctx) error {
+		once.Do(func() {
+			doc = docs.Build(app, info)
+		})
+		return c.JSON(doc)
+	}
+}
+
+// UI serves Swagger UI for the document at specURL.
+func UI(specURL string) fiber.Handler {
+	page := `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>API documentation</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "` + specURL + `", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>`
+
+	return func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(page)
+	}
+}
@@ -0,0 +1,342 @@
+// Package openapi describes the API as an OpenAPI 3.1 document. The paths
+// come from the routes registered on the Fiber app and the schemas from the
+// Go types of request bodies and responses, so the document cannot list
+// endpoints that do not exist.
+package openapi
+
+import (
+	"fmt"
+	"go-task/apperr"
+	"go-task/utils"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Version is the OpenAPI version of the documents Build returns.
+const Version = "3.1.0"
+
+type (
+	// Document is an OpenAPI document.
+	Document struct {
+		OpenAPI    string                           `json:"openapi"`
+		Info       Info                             `json:"info"`
+		Paths      map[string]map[string]*Operation `json:"paths"`
+		Components Components                       `json:"components"`
+	}
+
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description,omitempty"`
+	}
+
+	Components struct {
+		Schemas         map[string]*Schema         `json:"schemas"`
+		SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+	}
+
+	SecurityScheme struct {
+		Type        string `json:"type"`
+		Scheme      string `json:"scheme,omitempty"`
+		In          string `json:"in,omitempty"`
+		Name        string `json:"name,omitempty"`
+		Description string `json:"description,omitempty"`
+	}
+
+	// Operation is a single method of a path as it appears in the document.
+	Operation struct {
+		Summary     string                `json:"summary,omitempty"`
+		Description string                `json:"description,omitempty"`
+		OperationID string                `json:"operationId"`
+		Tags        []string              `json:"tags,omitempty"`
+		Parameters  []Parameter           `json:"parameters,omitempty"`
+		RequestBody *RequestBody          `json:"requestBody,omitempty"`
+		Responses   map[string]*Response  `json:"responses"`
+		Security    []map[string][]string `json:"security,omitempty"`
+	}
+
+	Parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *Schema `json:"schema"`
+	}
+
+	RequestBody struct {
+		Required bool                  `json:"required"`
+		Content  map[string]*MediaType `json:"content"`
+	}
+
+	Response struct {
+		Description string                `json:"description"`
+		Content     map[string]*MediaType `json:"content,omitempty"`
+	}
+
+	MediaType struct {
+		Schema *Schema `json:"schema"`
+	}
+)
+
+// Route documents one registered route. Body and Data are values of the
+// request body and of the data field of the response, Meta of the meta
+// field of list responses. Routes that do not answer with the JSON envelope
+// set ContentType instead, and Data when the body is a JSON object.
+type Route struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Auth is set on routes behind auth.Protected, Permission names the
+	// permission they also require, if any. SessionOnly routes refuse API
+	// keys.
+	Auth        bool
+	SessionOnly bool
+	Permission  string
+	Query       []Parameter
+	Body        any
+	Status      int
+	Data        any
+	Meta        any
+	ContentType string
+}
+
+// Docs collects the documentation of routes, keyed by method and path.
+type Docs struct {
+	routes map[string]Route
+}
+
+func NewDocs() *Docs {
+	return &Docs{routes: map[string]Route{}}
+}
+
+// Add documents the route registered for method and path, with the path
+// written as it is registered in Fiber, such as /api/products/:id.
+func (d *Docs) Add(method, path string, route Route) {
+	d.routes[method+" "+normalize(path)] = route
+}
+
+// Build describes every route of app. Routes without documentation are
+// listed without a summary and documented routes that were never
+// registered are listed as well, so drift shows up in the document.
+func (d *Docs) Build(app *fiber.App, info Info) *Document {
+	keys := map[string]bool{}
+	for key := range d.routes {
+		keys[key] = true
+	}
+	for _, r := range app.GetRoutes(true) {
+		if r.Method == fiber.MethodHead {
+			continue
+		}
+		keys[r.Method+" "+normalize(r.Path)] = true
+	}
+
+	s := &schemas{components: map[string]*Schema{}}
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: s.components,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"cookieAuth": {
+					Type:        "apiKey",
+					In:          "cookie",
+					Name:        utils.AccessTokenCookie,
+					Description: "Access token set by login and refresh.",
+				},
+				"apiKeyAuth": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        utils.APIKeyHeader,
+					Description: "Personal API key.",
+				},
+				"bearerAuth": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "Personal API key sent as a bearer token.",
+				},
+			},
+		},
+	}
+
+	// Error responses refer to these, describe them first.
+	s.of(apperr.Response{})
+	s.of(apperr.Problem{})
+	s.of(utils.FieldError{})
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		method, path, _ := strings.Cut(key, " ")
+		path, params := pathParams(path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*Operation{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = d.routes[key].operation(s, method, path, params)
+	}
+
+	return doc
+}
+
+func (r Route) operation(s *schemas, method, path string, params []Parameter) *Operation {
+	op := &Operation{
+		Summary:     r.Summary,
+		Description: r.Description,
+		OperationID: operationID(method, path),
+		Tags:        r.Tags,
+		Parameters:  append(params, r.Query...),
+		Responses:   map[string]*Response{},
+	}
+
+	if r.Permission != "" {
+		op.Description = strings.TrimSpace(op.Description + "\n\nRequires the " + r.Permission + " permission.")
+	}
+
+	if r.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{fiber.MIMEApplicationJSON: {Schema: s.of(r.Body)}},
+		}
+	}
+
+	status := r.Status
+	if status == 0 {
+		status = fiber.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if r.ContentType != "" {
+		body := &Schema{Type: "string"}
+		if r.Data != nil {
+			body = s.of(r.Data)
+		}
+		success.Content = map[string]*MediaType{r.ContentType: {Schema: body}}
+	} else {
+		success.Content = map[string]*MediaType{fiber.MIMEApplicationJSON: {Schema: envelope(s, r)}}
+	}
+	op.Responses[fmt.Sprint(status)] = success
+
+	errorStatuses := []int{}
+	if r.Body != nil || len(r.Query) > 0 {
+		errorStatuses = append(errorStatuses, fiber.StatusBadRequest)
+	}
+	if r.Auth {
+		op.Security = []map[string][]string{{"cookieAuth": {}}}
+		if !r.SessionOnly {
+			op.Security = append(op.Security, map[string][]string{"apiKeyAuth": {}}, map[string][]string{"bearerAuth": {}})
+		}
+		errorStatuses = append(errorStatuses, fiber.StatusUnauthorized)
+	}
+	if r.Permission != "" {
+		errorStatuses = append(errorStatuses, fiber.StatusForbidden)
+	}
+	if len(params) > 0 {
+		errorStatuses = append(errorStatuses, fiber.StatusNotFound)
+	}
+	for _, code := range errorStatuses {
+		op.Responses[fmt.Sprint(code)] = errorResponse(http.StatusText(code))
+	}
+	op.Responses["default"] = errorResponse("Error")
+
+	return op
+}
+
+// envelope is the schema of the success response of r.
+func envelope(s *schemas, r Route) *Schema {
+	schema := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"success": {Type: "boolean"},
+			"message": {Type: "string"},
+		},
+		Required: []string{"success", "message"},
+	}
+	if data := s.of(r.Data); data != nil {
+		schema.Properties["data"] = data
+	}
+	if meta := s.of(r.Meta); meta != nil {
+		schema.Properties["meta"] = meta
+	}
+	return schema
+}
+
+func errorResponse(description string) *Response {
+	return &Response{
+		Description: description,
+		Content: map[string]*MediaType{
+			fiber.MIMEApplicationJSON: {Schema: &Schema{Ref: "#/components/schemas/Response"}},
+			apperr.MIMEProblemJSON:    {Schema: &Schema{Ref: "#/components/schemas/Problem"}},
+		},
+	}
+}
+
+// ListParameters are the query parameters utils.ParseListQuery reads for
+// spec.
+func ListParameters(spec utils.ListSpec) []Parameter {
+	sortable := make([]string, 0, len(spec.Sortable))
+	for key := range spec.Sortable {
+		sortable = append(sortable, key)
+	}
+	sort.Strings(sortable)
+
+	params := []Parameter{
+		{Name: "limit", In: "query", Description: "Page size.", Schema: &Schema{
+			Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(utils.MaxPageLimit)),
+		}},
+		{Name: "offset", In: "query", Description: "Rows to skip, cannot be combined with cursor.", Schema: &Schema{
+			Type: "integer", Minimum: ptr(0.0),
+		}},
+		{Name: "cursor", In: "query", Description: "nextCursor of the previous page.", Schema: &Schema{Type: "string"}},
+		{Name: "sort", In: "query", Description: "Comma separated keys, prefixed with - to sort descending. One of " +
+			strings.Join(sortable, ", ") + ".", Schema: &Schema{Type: "string"}},
+	}
+
+	filters := make([]string, 0, len(spec.Filters))
+	for name := range spec.Filters {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+	for _, name := range filters {
+		params = append(params, Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+	}
+
+	return params
+}
+
+// normalize drops the trailing slash Fiber keeps on the root of groups,
+// such as /api/products/.
+func normalize(path string) string {
+	if len(path) > 1 {
+		return strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+// pathParams turns the :name parameters of a Fiber path into {name} and
+// describes them. Every path parameter of the API is an id.
+func pathParams(path string) (string, []Parameter) {
+	var params []Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+			params = append(params, Parameter{
+				Name: name, In: "path", Required: true,
+				Schema: &Schema{Type: "integer", Minimum: ptr(1.0)},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID derives a stable id such as get_api_products_id.
+func operationID(method, path string) string {
+	id := strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_").Replace(path)
+	return strings.TrimRight(id, "_")
+}
@@ -0,0 +1,210 @@
+package openapi
+
+import (
+	"go-task/utils"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas turns Go types into schemas, collecting named structs as
+// components so every type is described once.
+type schemas struct {
+	components map[string]*Schema
+}
+
+// of returns the schema of the type of v, or nil for a nil v.
+func (s *schemas) of(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	return s.forType(reflect.TypeOf(v))
+}
+
+func (s *schemas) forType(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.forType(t.Elem())
+		if typ, ok := schema.Type.(string); ok {
+			schema.Type = []string{typ, "null"}
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.forType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s.components[t.Name()]; !ok {
+			// Reserve the name first, the struct may refer to itself.
+			s.components[t.Name()] = nil
+			s.components[t.Name()] = s.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+// object describes the exported fields of a struct under their JSON names,
+// with the constraints of their validate tags.
+func (s *schemas) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := s.object(field.Type)
+			for key, property := range embedded.Properties {
+				schema.Properties[key] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.forType(field.Type)
+		if constrain(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+
+	return schema
+}
+
+// constrain adds the validate rules to schema and reports whether the field
+// is required.
+func constrain(schema *Schema, tag string) bool {
+	if tag == "" || schema.Ref != "" {
+		return false
+	}
+
+	required := false
+	var notes []string
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "min", "gte":
+			setBound(schema, param, true)
+		case "max", "lte":
+			setBound(schema, param, false)
+		case "len":
+			setBound(schema, param, true)
+			setBound(schema, param, false)
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "username":
+			schema.Pattern = utils.UsernamePattern.String()
+		case "sku":
+			schema.Pattern = utils.SKUPattern.String()
+		case "currency":
+			schema.Pattern = "^[A-Z]{3}$"
+			notes = append(notes, "ISO 4217 currency code")
+		case "password":
+			notes = append(notes, "at least "+strconv.Itoa(utils.MinPasswordClasses)+" of lowercase letters, uppercase letters, digits and symbols")
+		case "unique":
+			notes = append(notes, "unique")
+		}
+	}
+
+	schema.Description = strings.Join(notes, "; ")
+	return required
+}
+
+// setBound applies min or max, which bound the length of strings and
+// arrays and the value of numbers.
+func setBound(schema *Schema, param string, lower bool) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+
+	typ := schema.Type
+	if types, ok := typ.([]string); ok {
+		typ = types[0]
+	}
+
+	switch typ {
+	case "string":
+		if lower {
+			schema.MinLength = ptr(int(n))
+		} else {
+			schema.MaxLength = ptr(int(n))
+		}
+	case "array":
+		if lower {
+			schema.MinItems = ptr(int(n))
+		} else {
+			schema.MaxItems = ptr(int(n))
+		}
+	case "integer", "number":
+		if lower {
+			schema.Minimum = ptr(n)
+		} else {
+			schema.Maximum = ptr(n)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// NewGorm returns repositories backed by db.
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:          &gormUsers{db: db},
+		Products:       &gormProducts{db: db},
+		Sessions:       &gormSessions{db: db},
+		Roles:          &gormRoles{db: db},
+		PasswordResets: &gormPasswordResets{db: db},
+		RecoveryCodes:  &gormRecoveryCodes{db: db},
+		APIKeys:        &gormAPIKeys{db: db},
+		SigningKeys:    &gormSigningKeys{db: db},
+		RateLimits:     &gormRateLimits{db: db},
+		Status:         newGormStatus(db),
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// uniqueConstraint returns the name of the unique constraint err violates.
+// SQLite only reports the offending column, so the name is derived the way
+// the migrations name unique constraints, uni_<table>_<column>.
+func uniqueConstraint(db *gorm.DB, err error) (string, bool) {
+	switch db.Dialector.Name() {
+	case "postgres":
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return pgErr.ConstraintName, true
+		}
+	case "sqlite":
+		_, columns, ok := strings.Cut(err.Error(), "UNIQUE constraint failed: ")
+		if !ok {
+			return "", false
+		}
+		// e.g. "users.email (2067)" or "roles.id, roles.name"
+		column, _, _ := strings.Cut(columns, ",")
+		column, _, _ = strings.Cut(column, " ")
+		table, column, ok := strings.Cut(column, ".")
+		if ok {
+			return db.NamingStrategy.UniqueName(table, column), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormAPIKeys struct {
+	db *gorm.DB
+}
+
+func replaceAPIKeyScopes(tx *gorm.DB, keyId uint, scopes []string) error {
+	if err := tx.Where("api_key_id = ?", keyId).Delete(&models.APIKeyScopes{}).Error; err != nil {
+		return err
+	}
+
+	for _, scope := range scopes {
+		s := models.APIKeyScopes{APIKeyID: keyId, Scope: scope}
+		if err := tx.Create(&s).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *gormAPIKeys) Create(ctx context.Context, key *models.APIKeys, scopes []string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Scopes").Create(key).Error; err != nil {
+			return err
+		}
+		return replaceAPIKeyScopes(tx, key.Id, scopes)
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Preload("Scopes").First(key, key.Id).Error
+}
+
+func (r *gormAPIKeys) FindByID(ctx context.Context, id uint) (models.APIKeys, error) {
+	var key models.APIKeys
+	err := r.db.WithContext(ctx).Preload("Scopes").First(&key, id).Error
+	return key, notFound(err)
+}
+
+func (r *gormAPIKeys) FindByHash(ctx context.Context, keyHash string) (models.APIKeys, error) {
+	var key models.APIKeys
+	err := r.db.WithContext(ctx).Preload("Scopes").Where("key_hash = ?", keyHash).First(&key).Error
+	return key, notFound(err)
+}
+
+func (r *gormAPIKeys) ListByUser(ctx context.Context, userID uint) ([]models.APIKeys, error) {
+	keys := []models.APIKeys{}
+	err := r.db.WithContext(ctx).Preload("Scopes").Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormAPIKeys) Update(ctx context.Context, id uint, name *string, scopes *[]string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if name != nil {
+			if err := tx.Model(&models.APIKeys{}).Where("id = ?", id).Update("name", *name).Error; err != nil {
+				return err
+			}
+		}
+		if scopes != nil {
+			return replaceAPIKeyScopes(tx, id, *scopes)
+		}
+		return nil
+	})
+}
+
+func (r *gormAPIKeys) Revoke(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.APIKeys{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+func (r *gormAPIKeys) RevokeByUser(ctx context.Context, userID uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.APIKeys{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+func (r *gormAPIKeys) Touch(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.APIKeys{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
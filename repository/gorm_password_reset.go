@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormPasswordResets struct {
+	db *gorm.DB
+}
+
+func (r *gormPasswordResets) Create(ctx context.Context, token *models.PasswordResetTokens) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.PasswordResetTokens{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *gormPasswordResets) Consume(ctx context.Context, tokenHash string) (models.PasswordResetTokens, error) {
+	var token models.PasswordResetTokens
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+			return notFound(err)
+		}
+
+		// Only one request may spend a given token.
+		now := time.Now()
+		res := tx.Model(&models.PasswordResetTokens{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", token.Id, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTokenInvalid
+		}
+		token.UsedAt = &now
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		err = ErrTokenInvalid
+	}
+	return token, err
+}
+
+func (r *gormPasswordResets) PurgeExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&models.PasswordResetTokens{}).Error
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"go-task/utils"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type gormProducts struct {
+	db *gorm.DB
+}
+
+const fullTextSearchQuery = `
+	WITH query AS (SELECT websearch_to_tsquery('english', @q) AS q)
+	SELECT products.*,
+		ts_rank(products.search_vector, query.q) AS rank,
+		ts_headline('english', products.name || ' - ' || coalesce(products.description, ''), query.q,
+			@headline) AS snippet
+	FROM products, query
+	WHERE products.search_vector @@ query.q AND (@owner = 0 OR products.user_id = @owner)
+	ORDER BY rank DESC, products.id
+	LIMIT @limit`
+
+const fuzzySearchQuery = `
+	SELECT products.*,
+		greatest(word_similarity(@q, products.name), word_similarity(@q, coalesce(products.description, ''))) AS rank,
+		products.name AS snippet
+	FROM products
+	WHERE (@q <% products.name OR @q <% coalesce(products.description, ''))
+		AND (@owner = 0 OR products.user_id = @owner)
+	ORDER BY rank DESC, products.id
+	LIMIT @limit`
+
+const sqliteSearchQuery = `
+	SELECT products.*,
+		-bm25(products_fts, 2.0, 1.0) AS rank,
+		snippet(products_fts, -1, @start, @stop, '...', 20) AS snippet
+	FROM products_fts
+	JOIN products ON products.id = products_fts.rowid
+	WHERE products_fts MATCH @q AND (@owner = 0 OR products.user_id = @owner)
+	ORDER BY rank DESC, products.id
+	LIMIT @limit`
+
+// conflict maps unique constraint violations on products to typed errors.
+func (r *gormProducts) conflict(err error) error {
+	if err == nil {
+		return nil
+	}
+	if constraint, ok := uniqueConstraint(r.db, err); ok && constraint == "uni_products_sku" {
+		return ErrDuplicateSKU
+	}
+	return err
+}
+
+func (r *gormProducts) Create(ctx context.Context, product *models.Products) error {
+	return r.conflict(r.db.WithContext(ctx).Create(product).Error)
+}
+
+func (r *gormProducts) FindByID(ctx context.Context, id uint) (models.Products, error) {
+	var product models.Products
+	err := r.db.WithContext(ctx).First(&product, id).Error
+	return product, notFound(err)
+}
+
+func (r *gormProducts) FindBySKU(ctx context.Context, sku string) (models.Products, error) {
+	var product models.Products
+	err := r.db.WithContext(ctx).Where("sku = ?", sku).First(&product).Error
+	return product, notFound(err)
+}
+
+func (r *gormProducts) List(ctx context.Context, query utils.ListQuery) ([]models.Products, utils.ListMeta, error) {
+	return utils.Paginate[models.Products](r.db.WithContext(ctx), query)
+}
+
+func (r *gormProducts) Update(ctx context.Context, id uint, changes ProductChanges) error {
+	updates := make(map[string]interface{})
+
+	if changes.Name != nil {
+		updates["name"] = *changes.Name
+	}
+	if changes.Description != nil {
+		updates["description"] = *changes.Description
+	}
+	if changes.Quantity != nil {
+		updates["quantity"] = *changes.Quantity
+	}
+	if changes.Price != nil {
+		updates["price"] = *changes.Price
+	}
+	if changes.SKU != nil {
+		updates["sku"] = *changes.SKU
+	}
+	if changes.Currency != nil {
+		updates["currency"] = *changes.Currency
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return r.conflict(r.db.WithContext(ctx).Model(&models.Products{Id: id}).Updates(updates).Error)
+}
+
+func (r *gormProducts) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&models.Products{}, id).Error
+}
+
+// Search ranks products by full-text match on name and description. When
+// nothing matches, typically because of a typo, it falls back to trigram
+// similarity.
+func (r *gormProducts) Search(ctx context.Context, q string, ownerID uint, limit int) ([]ProductSearchResult, string, error) {
+	if r.db.Dialector.Name() == "sqlite" {
+		return r.searchSQLite(ctx, q, ownerID, limit)
+	}
+
+	args := map[string]interface{}{
+		"q":        q,
+		"owner":    ownerID,
+		"limit":    limit,
+		"headline": "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MaxWords=20, MinWords=5",
+	}
+
+	results := []ProductSearchResult{}
+	if err := r.db.WithContext(ctx).Raw(fullTextSearchQuery, args).Scan(&results).Error; err != nil {
+		return nil, "", err
+	}
+
+	if len(results) > 0 {
+		return highlight(results), "fulltext", nil
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The default threshold of 0.6 misses most single typos.
+		if err := tx.Exec("SET LOCAL pg_trgm.word_similarity_threshold = 0.4").Error; err != nil {
+			return err
+		}
+		return tx.Raw(fuzzySearchQuery, args).Scan(&results).Error
+	})
+
+	return highlight(results), "fuzzy", err
+}
+
+// searchSQLite uses the FTS5 index for exact matches. SQLite has no trigram
+// similarity, so the fuzzy fallback scores the products in process.
+func (r *gormProducts) searchSQLite(ctx context.Context, q string, ownerID uint, limit int) ([]ProductSearchResult, string, error) {
+	terms := wordPattern.FindAllString(q, -1)
+
+	results := []ProductSearchResult{}
+	if len(terms) > 0 {
+		// Quote every word so FTS5 operators in the input are matched literally.
+		match := `"` + strings.Join(terms, `" "`) + `"`
+		args := map[string]interface{}{"q": match, "owner": ownerID, "limit": limit, "start": highlightStart, "stop": highlightStop}
+		if err := r.db.WithContext(ctx).Raw(sqliteSearchQuery, args).Scan(&results).Error; err != nil {
+			return nil, "", err
+		}
+	}
+
+	if len(results) > 0 {
+		return highlight(results), "fulltext", nil
+	}
+
+	db := r.db.WithContext(ctx)
+	if ownerID != 0 {
+		db = db.Where("user_id = ?", ownerID)
+	}
+	products := []models.Products{}
+	if err := db.Order("id").Find(&products).Error; err != nil {
+		return nil, "", err
+	}
+
+	return matchProducts(products, q, "fuzzy", limit), "fuzzy", nil
+}
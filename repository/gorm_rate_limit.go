@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormRateLimits struct {
+	db *gorm.DB
+}
+
+// hitQuery counts a request in one statement, so concurrent requests on the
+// same bucket never lose a hit.
+const hitQuery = `
+	INSERT INTO rate_limits (bucket, hits, reset_at) VALUES (@bucket, 1, @reset)
+	ON CONFLICT (bucket) DO UPDATE SET
+		hits = CASE WHEN rate_limits.reset_at <= @now THEN 1 ELSE rate_limits.hits + 1 END,
+		reset_at = CASE WHEN rate_limits.reset_at <= @now THEN @reset ELSE rate_limits.reset_at END
+	RETURNING hits, reset_at`
+
+func (r *gormRateLimits) Hit(ctx context.Context, bucket string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	args := map[string]interface{}{"bucket": bucket, "now": now, "reset": now.Add(window)}
+
+	var row models.RateLimits
+	err := r.db.WithContext(ctx).Raw(hitQuery, args).Scan(&row).Error
+	return row.Hits, row.ResetAt, err
+}
+
+func (r *gormRateLimits) PurgeExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("reset_at < ?", before).Delete(&models.RateLimits{}).Error
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormRecoveryCodes struct {
+	db *gorm.DB
+}
+
+func (r *gormRecoveryCodes) Replace(ctx context.Context, userID uint, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCodes{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+
+		codes := make([]models.RecoveryCodes, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.RecoveryCodes{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *gormRecoveryCodes) Consume(ctx context.Context, userID uint, codeHash string) error {
+	// Only one request may spend a given code.
+	res := r.db.WithContext(ctx).Model(&models.RecoveryCodes{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+
+	"gorm.io/gorm"
+)
+
+type gormRoles struct {
+	db *gorm.DB
+}
+
+func replaceRolePermissions(tx *gorm.DB, roleId uint, permissions []string) error {
+	if err := tx.Where("role_id = ?", roleId).Delete(&models.RolePermissions{}).Error; err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		rp := models.RolePermissions{RoleID: roleId, Permission: permission}
+		if err := tx.Create(&rp).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *gormRoles) List(ctx context.Context) ([]models.Roles, error) {
+	roles := []models.Roles{}
+	err := r.db.WithContext(ctx).Preload("Permissions").Order("id").Find(&roles).Error
+	return roles, err
+}
+
+func (r *gormRoles) FindByID(ctx context.Context, id uint) (models.Roles, error) {
+	var role models.Roles
+	err := r.db.WithContext(ctx).Preload("Permissions").First(&role, id).Error
+	return role, notFound(err)
+}
+
+func (r *gormRoles) FindByName(ctx context.Context, name string) (models.Roles, error) {
+	var role models.Roles
+	err := r.db.WithContext(ctx).Preload("Permissions").Where("name = ?", name).First(&role).Error
+	return role, notFound(err)
+}
+
+func (r *gormRoles) Create(ctx context.Context, role *models.Roles, permissions []string) error {
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&models.Roles{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDuplicateName
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Permissions").Create(role).Error; err != nil {
+			return err
+		}
+		return replaceRolePermissions(tx, role.Id, permissions)
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithContext(ctx).Preload("Permissions").First(role, role.Id).Error
+}
+
+func (r *gormRoles) Update(ctx context.Context, id uint, description *string, permissions *[]string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if description != nil {
+			if err := tx.Model(&models.Roles{}).Where("id = ?", id).Update("description", *description).Error; err != nil {
+				return err
+			}
+		}
+		if permissions != nil {
+			return replaceRolePermissions(tx, id, *permissions)
+		}
+		return nil
+	})
+}
+
+func (r *gormRoles) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&models.RolePermissions{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Roles{}, id).Error
+	})
+}
+
+func (r *gormRoles) Permissions(ctx context.Context, role models.Role) ([]string, error) {
+	permissions := []string{}
+	err := r.db.WithContext(ctx).Model(&models.RolePermissions{}).
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Where("roles.name = ?", string(role)).
+		Pluck("role_permissions.permission", &permissions).Error
+
+	return permissions, err
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormSessions struct {
+	db *gorm.DB
+}
+
+func (f SessionFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+	if f.FamilyID != "" {
+		db = db.Where("family_id = ?", f.FamilyID)
+	}
+	return db
+}
+
+func (r *gormSessions) CreateRefreshToken(ctx context.Context, token *models.RefreshTokens) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *gormSessions) FindRefreshToken(ctx context.Context, tokenHash string) (models.RefreshTokens, error) {
+	var token models.RefreshTokens
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error
+	return token, notFound(err)
+}
+
+func (r *gormSessions) RotateRefreshToken(ctx context.Context, current models.RefreshTokens, next *models.RefreshTokens) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Only one request may rotate a given token, a concurrent request
+		// racing on the same token is treated as reuse.
+		res := tx.Model(&models.RefreshTokens{}).
+			Where("id = ? AND revoked_at IS NULL", current.Id).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTokenReused
+		}
+
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.RefreshTokens{}).
+			Where("id = ?", current.Id).
+			Update("replaced_by", next.Id).Error
+	})
+}
+
+func (r *gormSessions) ListRefreshTokens(ctx context.Context, filter SessionFilter, createdAfter time.Time) ([]models.RefreshTokens, error) {
+	tokens := []models.RefreshTokens{}
+	err := filter.apply(r.db.WithContext(ctx)).Where("created_at > ?", createdAfter).Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *gormSessions) RevokeRefreshTokens(ctx context.Context, filter SessionFilter, at time.Time) error {
+	return filter.apply(r.db.WithContext(ctx).Model(&models.RefreshTokens{})).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", at).Error
+}
+
+// RevokeAccessTokens adds tokens to the revocation list and drops entries
+// whose tokens have expired on their own.
+func (r *gormSessions) RevokeAccessTokens(ctx context.Context, tokens ...models.RevokedTokens) error {
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.RevokedTokens{}).Error; err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&tokens).Error
+}
+
+func (r *gormSessions) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RevokedTokens{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormSessions) PurgeExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", before).Delete(&models.RevokedTokens{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("expires_at < ?", before).Delete(&models.RefreshTokens{}).Error
+	})
+}
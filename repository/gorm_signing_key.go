@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormSigningKeys struct {
+	db *gorm.DB
+}
+
+func (r *gormSigningKeys) Create(ctx context.Context, key *models.SigningKeys) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *gormSigningKeys) ListValid(ctx context.Context, at time.Time) ([]models.SigningKeys, error) {
+	var keys []models.SigningKeys
+	err := r.db.WithContext(ctx).Where("expires_at > ?", at).Order("activates_at, id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormSigningKeys) PurgeExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&models.SigningKeys{}).Error
+}
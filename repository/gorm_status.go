@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"go-task/database"
+
+	"gorm.io/gorm"
+)
+
+// gormStatus compares the schema with the embedded migrations, which are
+// read once when it is created.
+type gormStatus struct {
+	db         *gorm.DB
+	migrations []database.Migration
+	loadErr    error
+}
+
+func newGormStatus(db *gorm.DB) *gormStatus {
+	migrations, err := database.MigrationsFor(db)
+	return &gormStatus{db: db, migrations: migrations, loadErr: err}
+}
+
+func (r *gormStatus) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (r *gormStatus) SchemaVersion(ctx context.Context) (database.SchemaVersion, error) {
+	if r.loadErr != nil {
+		return database.SchemaVersion{}, r.loadErr
+	}
+	return database.CheckMigrations(ctx, r.db, r.migrations)
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"go-task/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+// conflict maps unique constraint violations on users to typed errors.
+func (r *gormUsers) conflict(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	constraint, ok := uniqueConstraint(r.db, err)
+	if !ok {
+		return err
+	}
+
+	switch constraint {
+	case "uni_users_email":
+		return ErrDuplicateEmail
+	case "uni_users_username":
+		return ErrDuplicateUsername
+	}
+	return err
+}
+
+func (r *gormUsers) Create(ctx context.Context, user *models.Users) error {
+	return r.conflict(r.db.WithContext(ctx).Create(user).Error)
+}
+
+func (r *gormUsers) FindByID(ctx context.Context, id uint) (models.Users, error) {
+	var user models.Users
+	err := r.db.WithContext(ctx).First(&user, id).Error
+	return user, notFound(err)
+}
+
+func (r *gormUsers) FindByUsername(ctx context.Context, username string) (models.Users, error) {
+	var user models.Users
+	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	return user, notFound(err)
+}
+
+func (r *gormUsers) FindByEmail(ctx context.Context, email string) (models.Users, error) {
+	var user models.Users
+	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	return user, notFound(err)
+}
+
+func (r *gormUsers) List(ctx context.Context, query utils.ListQuery) ([]models.Users, utils.ListMeta, error) {
+	return utils.Paginate[models.Users](r.db.WithContext(ctx), query)
+}
+
+func (r *gormUsers) Save(ctx context.Context, user *models.Users) error {
+	return r.conflict(r.db.WithContext(ctx).Save(user).Error)
+}
+
+func (r *gormUsers) UpdateRole(ctx context.Context, id uint, role models.Role) error {
+	return r.db.WithContext(ctx).Model(&models.Users{}).Where("id = ?", id).Update("role", string(role)).Error
+}
+
+func (r *gormUsers) CountByRole(ctx context.Context, role models.Role) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Users{}).Where("role = ?", string(role)).Count(&count).Error
+	return count, err
+}
+
+func (r *gormUsers) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&models.Users{}, id).Error
+}
+
+func (r *gormUsers) RecordLoginFailure(ctx context.Context, id uint) (int, error) {
+	var user models.Users
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Users{}).Where("id = ?", id).
+			UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1")).Error
+		if err != nil {
+			return err
+		}
+		return tx.Select("failed_logins").First(&user, id).Error
+	})
+	return user.FailedLogins, notFound(err)
+}
+
+func (r *gormUsers) Lock(ctx context.Context, id uint, until time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Users{}).Where("id = ?", id).UpdateColumn("locked_until", until).Error
+}
+
+func (r *gormUsers) MarkVerified(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Users{}).Where("id = ?", id).UpdateColumn("verified_at", at).Error
+}
+
+func (r *gormUsers) SetTOTP(ctx context.Context, id uint, secret *string, enabledAt *time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Users{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"totp_secret": secret, "mfa_enabled_at": enabledAt}).Error
+}
+
+func (r *gormUsers) UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	// The condition makes concurrent requests with the same code race for
+	// a single row update.
+	res := r.db.WithContext(ctx).Model(&models.Users{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		UpdateColumn("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *gormUsers) ResetLoginFailures(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&models.Users{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"failed_logins": 0, "locked_until": nil}).Error
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sort"
+)
+
+// NewMemory returns repositories that keep everything in process memory,
+// seeded with the built-in roles. Meant for tests and local experiments.
+func NewMemory() Repositories {
+	roles := newMemoryRoles()
+	for _, name := range sortedRoleNames() {
+		role := models.Roles{Name: string(name), BuiltIn: true}
+		roles.Create(context.Background(), &role, models.BuiltInRoles[name])
+	}
+
+	return Repositories{
+		Users:          newMemoryUsers(),
+		Products:       newMemoryProducts(),
+		Sessions:       newMemorySessions(),
+		Roles:          roles,
+		PasswordResets: newMemoryPasswordResets(),
+		RecoveryCodes:  newMemoryRecoveryCodes(),
+		APIKeys:        newMemoryAPIKeys(),
+		SigningKeys:    newMemorySigningKeys(),
+		RateLimits:     NewMemoryRateLimits(),
+		Status:         memoryStatus{},
+	}
+}
+
+func sortedRoleNames() []models.Role {
+	names := make([]models.Role, 0, len(models.BuiltInRoles))
+	for name := range models.BuiltInRoles {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
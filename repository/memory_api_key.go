@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryAPIKeys struct {
+	mu     sync.RWMutex
+	nextID uint
+	rows   map[uint]models.APIKeys
+}
+
+func newMemoryAPIKeys() *memoryAPIKeys {
+	return &memoryAPIKeys{rows: map[uint]models.APIKeys{}}
+}
+
+func apiKeyScopes(keyId uint, scopes []string) []models.APIKeyScopes {
+	result := make([]models.APIKeyScopes, 0, len(scopes))
+	for i, scope := range scopes {
+		result = append(result, models.APIKeyScopes{Id: uint(i + 1), APIKeyID: keyId, Scope: scope})
+	}
+	return result
+}
+
+func cloneAPIKey(key models.APIKeys) models.APIKeys {
+	key.Scopes = append([]models.APIKeyScopes(nil), key.Scopes...)
+	key.LastUsedAt = clonePtr(key.LastUsedAt)
+	key.RevokedAt = clonePtr(key.RevokedAt)
+	return key
+}
+
+func (r *memoryAPIKeys) Create(_ context.Context, key *models.APIKeys, scopes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	key.Id = r.nextID
+	key.Scopes = apiKeyScopes(key.Id, scopes)
+	key.CreatedAt = time.Now()
+	key.UpdatedAt = key.CreatedAt
+
+	r.rows[key.Id] = cloneAPIKey(*key)
+	return nil
+}
+
+func (r *memoryAPIKeys) FindByID(_ context.Context, id uint) (models.APIKeys, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.rows[id]
+	if !ok {
+		return models.APIKeys{}, ErrNotFound
+	}
+	return cloneAPIKey(key), nil
+}
+
+func (r *memoryAPIKeys) FindByHash(_ context.Context, keyHash string) (models.APIKeys, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.rows {
+		if key.KeyHash == keyHash {
+			return cloneAPIKey(key), nil
+		}
+	}
+	return models.APIKeys{}, ErrNotFound
+}
+
+func (r *memoryAPIKeys) ListByUser(_ context.Context, userID uint) ([]models.APIKeys, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []models.APIKeys{}
+	for _, key := range r.rows {
+		if key.UserID == userID {
+			keys = append(keys, cloneAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	return keys, nil
+}
+
+func (r *memoryAPIKeys) Update(_ context.Context, id uint, name *string, scopes *[]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	if name != nil {
+		key.Name = *name
+	}
+	if scopes != nil {
+		key.Scopes = apiKeyScopes(id, *scopes)
+	}
+	key.UpdatedAt = time.Now()
+	r.rows[id] = key
+	return nil
+}
+
+func (r *memoryAPIKeys) Revoke(_ context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.rows[id]
+	if !ok || key.RevokedAt != nil {
+		return nil
+	}
+	key.RevokedAt = &at
+	r.rows[id] = key
+	return nil
+}
+
+func (r *memoryAPIKeys) RevokeByUser(_ context.Context, userID uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, key := range r.rows {
+		if key.UserID == userID && key.RevokedAt == nil {
+			key.RevokedAt = &at
+			r.rows[id] = key
+		}
+	}
+	return nil
+}
+
+func (r *memoryAPIKeys) Touch(_ context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	key.LastUsedAt = &at
+	r.rows[id] = key
+	return nil
+}
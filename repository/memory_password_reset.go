@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sync"
+	"time"
+)
+
+type memoryPasswordResets struct {
+	mu     sync.Mutex
+	nextID uint
+	tokens map[uint]models.PasswordResetTokens
+}
+
+func newMemoryPasswordResets() *memoryPasswordResets {
+	return &memoryPasswordResets{tokens: map[uint]models.PasswordResetTokens{}}
+}
+
+func (r *memoryPasswordResets) Create(_ context.Context, token *models.PasswordResetTokens) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, stored := range r.tokens {
+		if stored.UserID == token.UserID && stored.UsedAt == nil {
+			stored.UsedAt = &now
+			r.tokens[id] = stored
+		}
+	}
+
+	r.nextID++
+	token.Id = r.nextID
+	token.CreatedAt = now
+	r.tokens[token.Id] = *token
+	return nil
+}
+
+func (r *memoryPasswordResets) Consume(_ context.Context, tokenHash string) (models.PasswordResetTokens, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range r.tokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			return models.PasswordResetTokens{}, ErrTokenInvalid
+		}
+		token.UsedAt = &now
+		r.tokens[id] = token
+		return token, nil
+	}
+	return models.PasswordResetTokens{}, ErrTokenInvalid
+}
+
+func (r *memoryPasswordResets) PurgeExpired(_ context.Context, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"go-task/utils"
+	"html"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryProducts struct {
+	mu     sync.RWMutex
+	nextID uint
+	rows   map[uint]models.Products
+}
+
+func newMemoryProducts() *memoryProducts {
+	return &memoryProducts{rows: map[uint]models.Products{}}
+}
+
+// checkUnique mirrors the unique constraint on sku. Callers hold r.mu.
+func (r *memoryProducts) checkUnique(id uint, sku *string) error {
+	if sku == nil {
+		return nil
+	}
+	for _, row := range r.rows {
+		if row.Id != id && row.SKU != nil && *row.SKU == *sku {
+			return ErrDuplicateSKU
+		}
+	}
+	return nil
+}
+
+func (r *memoryProducts) Create(_ context.Context, product *models.Products) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUnique(0, product.SKU); err != nil {
+		return err
+	}
+	if product.Currency == "" {
+		product.Currency = models.DefaultCurrency
+	}
+
+	r.nextID++
+	product.Id = r.nextID
+	product.CreatedAt = time.Now()
+	product.UpdatedAt = product.CreatedAt
+
+	r.rows[product.Id] = *product
+	return nil
+}
+
+func (r *memoryProducts) FindByID(_ context.Context, id uint) (models.Products, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	product, ok := r.rows[id]
+	if !ok {
+		return models.Products{}, ErrNotFound
+	}
+	return product, nil
+}
+
+func (r *memoryProducts) FindBySKU(_ context.Context, sku string) (models.Products, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, product := range r.rows {
+		if product.SKU != nil && *product.SKU == sku {
+			return product, nil
+		}
+	}
+	return models.Products{}, ErrNotFound
+}
+
+func (r *memoryProducts) all() []models.Products {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]models.Products, 0, len(r.rows))
+	for _, product := range r.rows {
+		all = append(all, product)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Id < all[j].Id })
+	return all
+}
+
+func (r *memoryProducts) List(_ context.Context, query utils.ListQuery) ([]models.Products, utils.ListMeta, error) {
+	return utils.PaginateSlice(r.all(), query)
+}
+
+func (r *memoryProducts) Update(_ context.Context, id uint, changes ProductChanges) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+
+	if changes.Name != nil {
+		product.Name = *changes.Name
+	}
+	if changes.Description != nil {
+		product.Description = *changes.Description
+	}
+	if changes.Quantity != nil {
+		product.Quantity = *changes.Quantity
+	}
+	if changes.Price != nil {
+		product.Price = *changes.Price
+	}
+	if changes.SKU != nil {
+		if err := r.checkUnique(id, changes.SKU); err != nil {
+			return err
+		}
+		product.SKU = changes.SKU
+	}
+	if changes.Currency != nil {
+		product.Currency = *changes.Currency
+	}
+	product.UpdatedAt = time.Now()
+
+	r.rows[id] = product
+	return nil
+}
+
+func (r *memoryProducts) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rows, id)
+	return nil
+}
+
+var wordPattern = regexp.MustCompile(`\w+`)
+
+// Search marks matches with characters from the private use area, which
+// highlight turns into <mark> tags once the text around them is escaped.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+var highlighter = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// highlight HTML-escapes the snippets of results so product text cannot
+// inject markup, keeping only the <mark> tags around matches.
+func highlight(results []ProductSearchResult) []ProductSearchResult {
+	for i := range results {
+		results[i].Snippet = highlighter.Replace(html.EscapeString(results[i].Snippet))
+	}
+	return results
+}
+
+// Search approximates the database search: every query word has to appear
+// in the name or description, otherwise words within two edits match.
+func (r *memoryProducts) Search(_ context.Context, q string, ownerID uint, limit int) ([]ProductSearchResult, string, error) {
+	all := r.all()
+	if ownerID != 0 {
+		all = slices.DeleteFunc(all, func(p models.Products) bool { return p.UserID != ownerID })
+	}
+	if results := matchProducts(all, q, "fulltext", limit); len(results) > 0 {
+		return results, "fulltext", nil
+	}
+	return matchProducts(all, q, "fuzzy", limit), "fuzzy", nil
+}
+
+// matchProducts ranks products by how many of the words in q they contain.
+// In fuzzy mode words within two edits of a query word count as well.
+func matchProducts(products []models.Products, q string, mode string, limit int) []ProductSearchResult {
+	terms := wordPattern.FindAllString(strings.ToLower(q), -1)
+
+	results := []ProductSearchResult{}
+	for _, product := range products {
+		text := product.Name + " - " + product.Description
+		words := wordPattern.FindAllString(strings.ToLower(text), -1)
+
+		rank, matched := 0.0, map[string]bool{}
+		for _, term := range terms {
+			found := false
+			for _, word := range words {
+				if word == term || (mode == "fuzzy" && levenshtein(word, term) <= 2) {
+					found = true
+					matched[word] = true
+					rank++
+				}
+			}
+			if !found {
+				rank = 0
+				break
+			}
+		}
+
+		if rank == 0 {
+			continue
+		}
+
+		snippet := wordPattern.ReplaceAllStringFunc(text, func(word string) string {
+			if matched[strings.ToLower(word)] {
+				return highlightStart + word + highlightStop
+			}
+			return word
+		})
+
+		results = append(results, ProductSearchResult{Products: product, Rank: rank, Snippet: snippet})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return highlight(results)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
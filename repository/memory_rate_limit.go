@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sync"
+	"time"
+)
+
+type memoryRateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]models.RateLimits
+}
+
+// NewMemoryRateLimits returns a rate limit store local to the process, for
+// deployments running a single instance.
+func NewMemoryRateLimits() RateLimitRepository {
+	return &memoryRateLimits{buckets: map[string]models.RateLimits{}}
+}
+
+func (r *memoryRateLimits) Hit(_ context.Context, bucket string, window time.Duration) (int, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	row, ok := r.buckets[bucket]
+	if !ok || !row.ResetAt.After(now) {
+		row = models.RateLimits{Bucket: bucket, ResetAt: now.Add(window)}
+	}
+	row.Hits++
+	r.buckets[bucket] = row
+
+	return row.Hits, row.ResetAt, nil
+}
+
+func (r *memoryRateLimits) PurgeExpired(_ context.Context, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for bucket, row := range r.buckets {
+		if row.ResetAt.Before(before) {
+			delete(r.buckets, bucket)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sync"
+	"time"
+)
+
+type memoryRecoveryCodes struct {
+	mu     sync.Mutex
+	nextID uint
+	codes  map[uint]models.RecoveryCodes
+}
+
+func newMemoryRecoveryCodes() *memoryRecoveryCodes {
+	return &memoryRecoveryCodes{codes: map[uint]models.RecoveryCodes{}}
+}
+
+func (r *memoryRecoveryCodes) Replace(_ context.Context, userID uint, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, code := range r.codes {
+		if code.UserID == userID {
+			delete(r.codes, id)
+		}
+	}
+
+	now := time.Now()
+	for _, hash := range codeHashes {
+		r.nextID++
+		r.codes[r.nextID] = models.RecoveryCodes{Id: r.nextID, UserID: userID, CodeHash: hash, CreatedAt: now}
+	}
+	return nil
+}
+
+func (r *memoryRecoveryCodes) Consume(_ context.Context, userID uint, codeHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, code := range r.codes {
+		if code.UserID != userID || code.CodeHash != codeHash || code.UsedAt != nil {
+			continue
+		}
+		now := time.Now()
+		code.UsedAt = &now
+		r.codes[id] = code
+		return nil
+	}
+	return ErrTokenInvalid
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryRoles struct {
+	mu     sync.RWMutex
+	nextID uint
+	rows   map[uint]models.Roles
+}
+
+func newMemoryRoles() *memoryRoles {
+	return &memoryRoles{rows: map[uint]models.Roles{}}
+}
+
+func rolePermissions(roleId uint, permissions []string) []models.RolePermissions {
+	result := make([]models.RolePermissions, 0, len(permissions))
+	for i, permission := range permissions {
+		result = append(result, models.RolePermissions{Id: uint(i + 1), RoleID: roleId, Permission: permission})
+	}
+	return result
+}
+
+func (r *memoryRoles) List(_ context.Context) ([]models.Roles, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roles := make([]models.Roles, 0, len(r.rows))
+	for _, role := range r.rows {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Id < roles[j].Id })
+	return roles, nil
+}
+
+func (r *memoryRoles) FindByID(_ context.Context, id uint) (models.Roles, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role, ok := r.rows[id]
+	if !ok {
+		return models.Roles{}, ErrNotFound
+	}
+	return role, nil
+}
+
+func (r *memoryRoles) FindByName(_ context.Context, name string) (models.Roles, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, role := range r.rows {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return models.Roles{}, ErrNotFound
+}
+
+func (r *memoryRoles) Create(_ context.Context, role *models.Roles, permissions []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.rows {
+		if existing.Name == role.Name {
+			return ErrDuplicateName
+		}
+	}
+
+	r.nextID++
+	role.Id = r.nextID
+	role.Permissions = rolePermissions(role.Id, permissions)
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+
+	r.rows[role.Id] = *role
+	return nil
+}
+
+func (r *memoryRoles) Update(_ context.Context, id uint, description *string, permissions *[]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+
+	if description != nil {
+		role.Description = *description
+	}
+	if permissions != nil {
+		role.Permissions = rolePermissions(id, *permissions)
+	}
+	role.UpdatedAt = time.Now()
+
+	r.rows[id] = role
+	return nil
+}
+
+func (r *memoryRoles) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rows, id)
+	return nil
+}
+
+func (r *memoryRoles) Permissions(ctx context.Context, name models.Role) ([]string, error) {
+	role, err := r.FindByName(ctx, string(name))
+	if err == ErrNotFound {
+		return []string{}, nil
+	}
+
+	permissions := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		permissions = append(permissions, p.Permission)
+	}
+	return permissions, nil
+}
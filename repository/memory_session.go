@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sync"
+	"time"
+)
+
+type memorySessions struct {
+	mu      sync.Mutex
+	nextID  uint
+	refresh map[uint]models.RefreshTokens
+	revoked map[string]models.RevokedTokens
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{
+		refresh: map[uint]models.RefreshTokens{},
+		revoked: map[string]models.RevokedTokens{},
+	}
+}
+
+func (f SessionFilter) matches(token models.RefreshTokens) bool {
+	return (f.UserID == 0 || token.UserID == f.UserID) &&
+		(f.FamilyID == "" || token.FamilyID == f.FamilyID)
+}
+
+func (r *memorySessions) create(token *models.RefreshTokens) {
+	r.nextID++
+	token.Id = r.nextID
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+	r.refresh[token.Id] = *token
+}
+
+func (r *memorySessions) CreateRefreshToken(_ context.Context, token *models.RefreshTokens) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.create(token)
+	return nil
+}
+
+func (r *memorySessions) FindRefreshToken(_ context.Context, tokenHash string) (models.RefreshTokens, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.refresh {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return models.RefreshTokens{}, ErrNotFound
+}
+
+func (r *memorySessions) RotateRefreshToken(_ context.Context, current models.RefreshTokens, next *models.RefreshTokens) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.refresh[current.Id]
+	if !ok || stored.RevokedAt != nil {
+		return ErrTokenReused
+	}
+
+	r.create(next)
+
+	now := time.Now()
+	stored.RevokedAt = &now
+	stored.ReplacedBy = &next.Id
+	r.refresh[stored.Id] = stored
+	return nil
+}
+
+func (r *memorySessions) ListRefreshTokens(_ context.Context, filter SessionFilter, createdAfter time.Time) ([]models.RefreshTokens, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tokens := []models.RefreshTokens{}
+	for _, token := range r.refresh {
+		if filter.matches(token) && token.CreatedAt.After(createdAfter) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (r *memorySessions) RevokeRefreshTokens(_ context.Context, filter SessionFilter, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.refresh {
+		if filter.matches(token) && token.RevokedAt == nil {
+			token.RevokedAt = &at
+			r.refresh[id] = token
+		}
+	}
+	return nil
+}
+
+func (r *memorySessions) RevokeAccessTokens(_ context.Context, tokens ...models.RevokedTokens) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for jti, token := range r.revoked {
+		if token.ExpiresAt.Before(now) {
+			delete(r.revoked, jti)
+		}
+	}
+
+	for _, token := range tokens {
+		if _, ok := r.revoked[token.Jti]; !ok {
+			token.CreatedAt = now
+			r.revoked[token.Jti] = token
+		}
+	}
+	return nil
+}
+
+func (r *memorySessions) IsAccessTokenRevoked(_ context.Context, jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.revoked[jti]
+	return ok, nil
+}
+
+func (r *memorySessions) PurgeExpired(_ context.Context, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for jti, token := range r.revoked {
+		if token.ExpiresAt.Before(before) {
+			delete(r.revoked, jti)
+		}
+	}
+	for id, token := range r.refresh {
+		if token.ExpiresAt.Before(before) {
+			delete(r.refresh, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memorySigningKeys struct {
+	mu     sync.Mutex
+	nextID uint
+	keys   map[uint]models.SigningKeys
+}
+
+func newMemorySigningKeys() *memorySigningKeys {
+	return &memorySigningKeys{keys: map[uint]models.SigningKeys{}}
+}
+
+func (r *memorySigningKeys) Create(_ context.Context, key *models.SigningKeys) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	key.Id = r.nextID
+	key.CreatedAt = time.Now()
+	r.keys[key.Id] = *key
+	return nil
+}
+
+func (r *memorySigningKeys) ListValid(_ context.Context, at time.Time) ([]models.SigningKeys, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := []models.SigningKeys{}
+	for _, key := range r.keys {
+		if key.ExpiresAt.After(at) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].ActivatesAt.Equal(keys[j].ActivatesAt) {
+			return keys[i].ActivatesAt.Before(keys[j].ActivatesAt)
+		}
+		return keys[i].Id < keys[j].Id
+	})
+	return keys, nil
+}
+
+func (r *memorySigningKeys) PurgeExpired(_ context.Context, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, key := range r.keys {
+		if key.ExpiresAt.Before(before) {
+			delete(r.keys, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"context"
+	"go-task/database"
+)
+
+// memoryStatus is always ready, there is nothing to connect to or migrate.
+type memoryStatus struct{}
+
+func (memoryStatus) Ping(context.Context) error {
+	return nil
+}
+
+func (memoryStatus) SchemaVersion(context.Context) (database.SchemaVersion, error) {
+	return database.SchemaVersion{}, nil
+}
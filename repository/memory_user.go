@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"context"
+	"go-task/models"
+	"go-task/utils"
+	"sync"
+	"time"
+)
+
+type memoryUsers struct {
+	mu     sync.RWMutex
+	nextID uint
+	rows   map[uint]models.Users
+}
+
+func newMemoryUsers() *memoryUsers {
+	return &memoryUsers{rows: map[uint]models.Users{}}
+}
+
+func cloneUser(user models.Users) models.Users {
+	user.Role = clonePtr(user.Role)
+	user.LastName = clonePtr(user.LastName)
+	user.LockedUntil = clonePtr(user.LockedUntil)
+	user.VerifiedAt = clonePtr(user.VerifiedAt)
+	user.TOTPSecret = clonePtr(user.TOTPSecret)
+	user.MFAEnabledAt = clonePtr(user.MFAEnabledAt)
+	user.Products = nil
+	return user
+}
+
+// conflict reports a unique violation of user against every other row.
+func (r *memoryUsers) conflict(user *models.Users) error {
+	for id, row := range r.rows {
+		if id == user.Id {
+			continue
+		}
+		if user.Email != "" && row.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+		if row.Username == user.Username {
+			return ErrDuplicateUsername
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) Create(_ context.Context, user *models.Users) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.conflict(user); err != nil {
+		return err
+	}
+
+	r.nextID++
+	user.Id = r.nextID
+	if user.Role == nil {
+		role := models.User
+		user.Role = &role
+	}
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	r.rows[user.Id] = cloneUser(*user)
+	return nil
+}
+
+func (r *memoryUsers) FindByID(_ context.Context, id uint) (models.Users, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return models.Users{}, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (r *memoryUsers) find(match func(models.Users) bool) (models.Users, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.rows {
+		if match(user) {
+			return cloneUser(user), nil
+		}
+	}
+	return models.Users{}, ErrNotFound
+}
+
+func (r *memoryUsers) FindByUsername(_ context.Context, username string) (models.Users, error) {
+	return r.find(func(user models.Users) bool { return user.Username == username })
+}
+
+func (r *memoryUsers) FindByEmail(_ context.Context, email string) (models.Users, error) {
+	return r.find(func(user models.Users) bool { return user.Email == email })
+}
+
+func (r *memoryUsers) List(_ context.Context, query utils.ListQuery) ([]models.Users, utils.ListMeta, error) {
+	r.mu.RLock()
+	all := make([]models.Users, 0, len(r.rows))
+	for _, user := range r.rows {
+		all = append(all, cloneUser(user))
+	}
+	r.mu.RUnlock()
+
+	return utils.PaginateSlice(all, query)
+}
+
+func (r *memoryUsers) Save(_ context.Context, user *models.Users) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.conflict(user); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+	r.rows[user.Id] = cloneUser(*user)
+	return nil
+}
+
+func (r *memoryUsers) UpdateRole(_ context.Context, id uint, role models.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	user.Role = &role
+	user.UpdatedAt = time.Now()
+	r.rows[id] = user
+	return nil
+}
+
+func (r *memoryUsers) CountByRole(_ context.Context, role models.Role) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, user := range r.rows {
+		if user.Role != nil && *user.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryUsers) Delete(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rows, id)
+	return nil
+}
+
+func (r *memoryUsers) RecordLoginFailure(_ context.Context, id uint) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	user.FailedLogins++
+	r.rows[id] = user
+	return user.FailedLogins, nil
+}
+
+func (r *memoryUsers) Lock(_ context.Context, id uint, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	user.LockedUntil = &until
+	r.rows[id] = user
+	return nil
+}
+
+func (r *memoryUsers) MarkVerified(_ context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	user.VerifiedAt = &at
+	r.rows[id] = user
+	return nil
+}
+
+func (r *memoryUsers) ResetLoginFailures(_ context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	r.rows[id] = user
+	return nil
+}
+
+func (r *memoryUsers) SetTOTP(_ context.Context, id uint, secret *string, enabledAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok {
+		return nil
+	}
+	user.TOTPSecret = clonePtr(secret)
+	user.MFAEnabledAt = clonePtr(enabledAt)
+	r.rows[id] = user
+	return nil
+}
+
+func (r *memoryUsers) UseTOTPStep(_ context.Context, id uint, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.rows[id]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	r.rows[id] = user
+	return true, nil
+}
@@ -0,0 +1,183 @@
+// Package repository hides where users, products, sessions and roles are
+// stored. Handlers only see the interfaces below; NewGorm backs them with the
+// database and NewMemory keeps everything in process for tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"go-task/database"
+	"go-task/models"
+	"go-task/utils"
+	"time"
+)
+
+var (
+	ErrNotFound          = errors.New("record not found")
+	ErrDuplicateEmail    = errors.New("email already registered")
+	ErrDuplicateUsername = errors.New("username already taken")
+	ErrDuplicateName     = errors.New("name already taken")
+	ErrDuplicateSKU      = errors.New("sku already taken")
+	ErrTokenReused       = errors.New("refresh token already used")
+	ErrTokenInvalid      = errors.New("token invalid, expired or already used")
+)
+
+type (
+	// ProductChanges holds the fields of a partial product update, nil
+	// fields are left untouched.
+	ProductChanges struct {
+		Name        *string
+		Description *string
+		Quantity    *uint
+		Price       *float64
+		SKU         *string
+		Currency    *string
+	}
+
+	ProductSearchResult struct {
+		models.Products
+		Rank    float64 `json:"rank"`
+		Snippet string  `json:"snippet"`
+	}
+
+	// SessionFilter selects refresh tokens by user or by token family.
+	SessionFilter struct {
+		UserID   uint
+		FamilyID string
+	}
+
+	UserRepository interface {
+		Create(ctx context.Context, user *models.Users) error
+		FindByID(ctx context.Context, id uint) (models.Users, error)
+		FindByUsername(ctx context.Context, username string) (models.Users, error)
+		FindByEmail(ctx context.Context, email string) (models.Users, error)
+		List(ctx context.Context, query utils.ListQuery) ([]models.Users, utils.ListMeta, error)
+		Save(ctx context.Context, user *models.Users) error
+		UpdateRole(ctx context.Context, id uint, role models.Role) error
+		CountByRole(ctx context.Context, role models.Role) (int64, error)
+		Delete(ctx context.Context, id uint) error
+		// RecordLoginFailure counts a failed login and returns the failures
+		// since the last successful one.
+		RecordLoginFailure(ctx context.Context, id uint) (int, error)
+		Lock(ctx context.Context, id uint, until time.Time) error
+		// ResetLoginFailures clears the failure count and any lock.
+		ResetLoginFailures(ctx context.Context, id uint) error
+		MarkVerified(ctx context.Context, id uint, at time.Time) error
+		// SetTOTP stores the TOTP secret of a user and when it was
+		// confirmed. Nil values turn two-factor authentication off.
+		SetTOTP(ctx context.Context, id uint, secret *string, enabledAt *time.Time) error
+		// UseTOTPStep records step as the period of the last accepted TOTP
+		// code. It returns false when a code of the same or a later period
+		// was accepted before.
+		UseTOTPStep(ctx context.Context, id uint, step int64) (bool, error)
+	}
+
+	ProductRepository interface {
+		Create(ctx context.Context, product *models.Products) error
+		FindByID(ctx context.Context, id uint) (models.Products, error)
+		List(ctx context.Context, query utils.ListQuery) ([]models.Products, utils.ListMeta, error)
+		FindBySKU(ctx context.Context, sku string) (models.Products, error)
+		Update(ctx context.Context, id uint, changes ProductChanges) error
+		Delete(ctx context.Context, id uint) error
+		// Search ranks products matching q and reports whether the match
+		// was exact ("fulltext") or approximate ("fuzzy"). A non-zero
+		// ownerID only searches the products of that user.
+		Search(ctx context.Context, q string, ownerID uint, limit int) ([]ProductSearchResult, string, error)
+	}
+
+	SessionRepository interface {
+		CreateRefreshToken(ctx context.Context, token *models.RefreshTokens) error
+		FindRefreshToken(ctx context.Context, tokenHash string) (models.RefreshTokens, error)
+		// RotateRefreshToken revokes current and stores next in one step.
+		// It returns ErrTokenReused when current was already revoked.
+		RotateRefreshToken(ctx context.Context, current models.RefreshTokens, next *models.RefreshTokens) error
+		ListRefreshTokens(ctx context.Context, filter SessionFilter, createdAfter time.Time) ([]models.RefreshTokens, error)
+		RevokeRefreshTokens(ctx context.Context, filter SessionFilter, at time.Time) error
+		RevokeAccessTokens(ctx context.Context, tokens ...models.RevokedTokens) error
+		IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+		// PurgeExpired deletes refresh tokens and revocation entries that
+		// expired before the given time.
+		PurgeExpired(ctx context.Context, before time.Time) error
+	}
+
+	RoleRepository interface {
+		List(ctx context.Context) ([]models.Roles, error)
+		FindByID(ctx context.Context, id uint) (models.Roles, error)
+		FindByName(ctx context.Context, name string) (models.Roles, error)
+		Create(ctx context.Context, role *models.Roles, permissions []string) error
+		Update(ctx context.Context, id uint, description *string, permissions *[]string) error
+		Delete(ctx context.Context, id uint) error
+		Permissions(ctx context.Context, role models.Role) ([]string, error)
+	}
+
+	PasswordResetRepository interface {
+		// Create stores token and spends the unused tokens the user was
+		// sent before, so only the latest email works.
+		Create(ctx context.Context, token *models.PasswordResetTokens) error
+		// Consume spends the token with tokenHash. It returns
+		// ErrTokenInvalid when there is no such token or it has expired or
+		// was spent already.
+		Consume(ctx context.Context, tokenHash string) (models.PasswordResetTokens, error)
+		PurgeExpired(ctx context.Context, before time.Time) error
+	}
+
+	RecoveryCodeRepository interface {
+		// Replace drops the recovery codes of a user and stores codeHashes
+		// instead.
+		Replace(ctx context.Context, userID uint, codeHashes []string) error
+		// Consume spends the unused code of the user with codeHash. It
+		// returns ErrTokenInvalid when there is none.
+		Consume(ctx context.Context, userID uint, codeHash string) error
+	}
+
+	APIKeyRepository interface {
+		Create(ctx context.Context, key *models.APIKeys, scopes []string) error
+		FindByID(ctx context.Context, id uint) (models.APIKeys, error)
+		// FindByHash returns the key with keyHash, revoked or not.
+		FindByHash(ctx context.Context, keyHash string) (models.APIKeys, error)
+		ListByUser(ctx context.Context, userID uint) ([]models.APIKeys, error)
+		Update(ctx context.Context, id uint, name *string, scopes *[]string) error
+		Revoke(ctx context.Context, id uint, at time.Time) error
+		// RevokeByUser revokes every key of the user that is not revoked
+		// yet.
+		RevokeByUser(ctx context.Context, userID uint, at time.Time) error
+		// Touch records that the key was used at the given time.
+		Touch(ctx context.Context, id uint, at time.Time) error
+	}
+
+	SigningKeyRepository interface {
+		Create(ctx context.Context, key *models.SigningKeys) error
+		// ListValid returns the keys that have not expired at the given
+		// time, in the order they activate.
+		ListValid(ctx context.Context, at time.Time) ([]models.SigningKeys, error)
+		PurgeExpired(ctx context.Context, before time.Time) error
+	}
+
+	// RateLimitRepository counts requests per bucket in fixed windows.
+	RateLimitRepository interface {
+		// Hit counts a request in bucket and returns the requests in the
+		// current window and when it ends. A new window starts with the
+		// first request after the previous one ended.
+		Hit(ctx context.Context, bucket string, window time.Duration) (int, time.Time, error)
+		PurgeExpired(ctx context.Context, before time.Time) error
+	}
+
+	// StatusRepository reports whether the storage can serve requests.
+	StatusRepository interface {
+		Ping(ctx context.Context) error
+		SchemaVersion(ctx context.Context) (database.SchemaVersion, error)
+	}
+
+	Repositories struct {
+		Users          UserRepository
+		Products       ProductRepository
+		Sessions       SessionRepository
+		Roles          RoleRepository
+		PasswordResets PasswordResetRepository
+		RecoveryCodes  RecoveryCodeRepository
+		APIKeys        APIKeyRepository
+		SigningKeys    SigningKeyRepository
+		RateLimits     RateLimitRepository
+		Status         StatusRepository
+	}
+)
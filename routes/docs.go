@@ -0,0 +1,254 @@
+package routes
+
+import (
+	"go-task/handler"
+	"go-task/keyset"
+	"go-task/models"
+	"go-task/openapi"
+	"go-task/repository"
+	"go-task/utils"
+	"go-task/version"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiInfo is the info block of the OpenAPI document.
+func apiInfo() openapi.Info {
+	return openapi.Info{
+		Title:       "go-task",
+		Version:     version.Get().Version,
+		Description: "Users, products and roles. Successful responses carry success, message and data, failed ones the error envelope.",
+	}
+}
+
+// apiDocs documents every route SetupRoutes registers. A route missing here,
+// or documented but not registered, fails TestOpenAPI, and Auth, SessionOnly
+// or Permission differing from the middleware of the route fails
+// TestOpenAPISecurity.
+func apiDocs() *openapi.Docs {
+	docs := openapi.NewDocs()
+
+	docs.Add(fiber.MethodGet, "/healthz", openapi.Route{
+		Summary: "Liveness probe", Tags: []string{"operations"},
+	})
+	docs.Add(fiber.MethodGet, "/readyz", openapi.Route{
+		Summary: "Readiness probe", Tags: []string{"operations"},
+		Description: "Answers 503 while the database is unreachable, migrations are pending or the server shuts down.",
+		Data:        fiber.Map{},
+	})
+	docs.Add(fiber.MethodGet, "/metrics", openapi.Route{
+		Summary: "Prometheus metrics", Tags: []string{"operations"},
+		ContentType: "text/plain",
+	})
+	docs.Add(fiber.MethodGet, "/.well-known/jwks.json", openapi.Route{
+		Summary: "Public keys of access tokens", Tags: []string{"operations"},
+		Description: "JSON Web Key Set to verify access tokens with, matched by their kid header. Lists keys before they start signing and after they stopped, for the rotation overlap.",
+		ContentType: fiber.MIMEApplicationJSON,
+		Data:        keyset.JWKS{},
+	})
+	docs.Add(fiber.MethodGet, "/openapi.json", openapi.Route{
+		Summary: "This document", Tags: []string{"operations"},
+		ContentType: fiber.MIMEApplicationJSON,
+	})
+	docs.Add(fiber.MethodGet, "/docs", openapi.Route{
+		Summary: "Swagger UI for this document", Tags: []string{"operations"},
+		ContentType: fiber.MIMETextHTML,
+	})
+
+	docs.Add(fiber.MethodPost, "/api/user/register", openapi.Route{
+		Summary: "Register a user", Tags: []string{"users"},
+		Body: handler.RegisterUserInput{},
+	})
+	docs.Add(fiber.MethodPost, "/api/user/login", openapi.Route{
+		Summary: "Log in with a username or email", Tags: []string{"users"},
+		Description: "Sets the access and refresh token cookies, the access token is also returned as data. " +
+			"For users with two-factor authentication on, data is an MFA challenge to complete at /api/user/login/mfa instead.",
+		Body: handler.LoginInput{}, Data: "",
+	})
+	docs.Add(fiber.MethodPost, "/api/user/login/mfa", openapi.Route{
+		Summary: "Complete a login with a TOTP or recovery code", Tags: []string{"users"},
+		Description: "Takes the mfaToken of the login response. Wrong codes count as failed logins.",
+		Body:        handler.MFALoginInput{}, Data: "",
+	})
+	docs.Add(fiber.MethodPost, "/api/user/refresh", openapi.Route{
+		Summary: "Rotate the refresh token", Tags: []string{"users"},
+		Description: "Reads the " + utils.RefreshTokenCookie + " cookie and sets new session cookies.",
+		Data:        "",
+	})
+	docs.Add(fiber.MethodPost, "/api/user/logout", openapi.Route{
+		Summary: "Log out and revoke the session", Tags: []string{"users"}, Auth: true, SessionOnly: true,
+	})
+	docs.Add(fiber.MethodPost, "/api/user/password/forgot", openapi.Route{
+		Summary: "Email a password reset link", Tags: []string{"users"},
+		Description: "Answers the same whether or not the email is registered.",
+		Body:        handler.ForgotPasswordInput{}, Status: fiber.StatusAccepted,
+	})
+	docs.Add(fiber.MethodPost, "/api/user/password/reset", openapi.Route{
+		Summary: "Set a new password with a reset token", Tags: []string{"users"},
+		Description: "Tokens work once. Every session of the user is revoked.",
+		Body:        handler.ResetPasswordInput{},
+	})
+	docs.Add(fiber.MethodGet, "/api/user/verify-email", openapi.Route{
+		Summary: "Verify an email address", Tags: []string{"users"},
+		Description: "Target of the link emailed on registration.",
+		Query: []openapi.Parameter{
+			{Name: "token", In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		},
+	})
+	docs.Add(fiber.MethodPost, "/api/user/verify-email/resend", openapi.Route{
+		Summary: "Email a new verification link", Tags: []string{"users"}, Auth: true,
+		Status: fiber.StatusAccepted,
+	})
+	docs.Add(fiber.MethodPost, "/api/user/mfa/enroll", openapi.Route{
+		Summary: "Start enrolling an authenticator app", Tags: []string{"users"}, Auth: true, SessionOnly: true,
+		Description: "Returns a new TOTP secret and its otpauth:// URI to show as a QR code.",
+		Data:        handler.MFAEnrollment{},
+	})
+	docs.Add(fiber.MethodPost, "/api/user/mfa/confirm", openapi.Route{
+		Summary: "Turn two-factor authentication on", Tags: []string{"users"}, Auth: true, SessionOnly: true,
+		Description: "Takes a first code from the enrolled app and returns one-time recovery codes. Every other session is revoked.",
+		Body:        handler.MFACodeInput{}, Data: handler.MFAConfirmation{},
+	})
+	docs.Add(fiber.MethodPost, "/api/user/mfa/recovery-codes", openapi.Route{
+		Summary: "Replace the recovery codes", Tags: []string{"users"}, Auth: true, SessionOnly: true,
+		Body: handler.MFACodeInput{}, Data: []string{},
+	})
+	docs.Add(fiber.MethodDelete, "/api/user/mfa", openapi.Route{
+		Summary: "Turn two-factor authentication off", Tags: []string{"users"}, Auth: true, SessionOnly: true,
+		Description: "Not allowed for admins, for whom it is mandatory.",
+		Body:        handler.MFACodeInput{},
+	})
+	docs.Add(fiber.MethodGet, "/api/user/api-keys", openapi.Route{
+		Summary: "List the API keys of the current user", Tags: []string{"api keys"}, Auth: true, SessionOnly: true,
+		Description: "Revoked keys are listed as well.",
+		Data:        []models.APIKeys{},
+	})
+	docs.Add(fiber.MethodPost, "/api/user/api-keys", openapi.Route{
+		Summary: "Create an API key", Tags: []string{"api keys"}, Auth: true, SessionOnly: true,
+		Description: "Scopes are permissions the role of the user grants. The key is only returned in this response.",
+		Body:        handler.CreateAPIKeyInput{}, Status: fiber.StatusCreated, Data: handler.NewAPIKey{},
+	})
+	docs.Add(fiber.MethodPatch, "/api/user/api-keys/:id", openapi.Route{
+		Summary: "Rename an API key or replace its scopes", Tags: []string{"api keys"}, Auth: true, SessionOnly: true,
+		Body: handler.UpdateAPIKeyInput{}, Data: models.APIKeys{},
+	})
+	docs.Add(fiber.MethodDelete, "/api/user/api-keys/:id", openapi.Route{
+		Summary: "Revoke an API key", Tags: []string{"api keys"}, Auth: true, SessionOnly: true,
+		Description: "Only the owner, or a user with the " + models.PermUsersWrite + " permission, may revoke a key.",
+	})
+	docs.Add(fiber.MethodGet, "/api/user/products", openapi.Route{
+		Summary: "List the products of the current user", Tags: []string{"products"}, Auth: true,
+		Query: openapi.ListParameters(handler.ProductListSpec),
+		Data:  []models.Products{}, Meta: utils.ListMeta{},
+	})
+
+	docs.Add(fiber.MethodGet, "/api/products", openapi.Route{
+		Summary: "List all products", Tags: []string{"products"},
+		Auth: true, Permission: models.PermProductsReadAny,
+		Query: openapi.ListParameters(handler.ProductListSpec),
+		Data:  []models.Products{}, Meta: utils.ListMeta{},
+	})
+	docs.Add(fiber.MethodGet, "/api/products/search", openapi.Route{
+		Summary: "Search products", Tags: []string{"products"},
+		Description: "Searches every product with products:read:any, otherwise only the caller's own.",
+		Auth:        true,
+		Query: []openapi.Parameter{
+			{Name: "q", In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+			{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		Data: []repository.ProductSearchResult{},
+		Meta: struct {
+			Query string `json:"query"`
+			Mode  string `json:"mode"`
+			Total int    `json:"total"`
+		}{},
+	})
+	docs.Add(fiber.MethodGet, "/api/products/:id", openapi.Route{
+		Summary: "Get a product", Tags: []string{"products"},
+		Data: models.Products{},
+	})
+	docs.Add(fiber.MethodPost, "/api/products", openapi.Route{
+		Summary: "Create a product", Tags: []string{"products"}, Auth: true,
+		Description: "Only users with a verified email address may create products. API keys need the " + models.PermProductsWrite + " scope.",
+		Body:        handler.CreateProductInput{}, Status: fiber.StatusCreated, Data: models.Products{},
+	})
+	docs.Add(fiber.MethodPatch, "/api/products/:id", openapi.Route{
+		Summary: "Update a product", Tags: []string{"products"}, Auth: true,
+		Description: "Only the owner, or a user with the " + models.PermProductsWriteAny + " permission, may update a product. " +
+			"API keys of the owner need the " + models.PermProductsWrite + " scope.",
+		Body: handler.UpdateProductInput{},
+	})
+	docs.Add(fiber.MethodDelete, "/api/products/:id", openapi.Route{
+		Summary: "Delete a product", Tags: []string{"products"}, Auth: true,
+		Description: "Only the owner, or a user with the " + models.PermProductsWriteAny + " permission, may delete a product. " +
+			"API keys of the owner need the " + models.PermProductsWrite + " scope.",
+	})
+
+	docs.Add(fiber.MethodGet, "/api/admin/all-user", openapi.Route{
+		Summary: "List users", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersRead,
+		Query: openapi.ListParameters(handler.UserListSpec),
+		Data:  []models.Users{}, Meta: utils.ListMeta{},
+	})
+	docs.Add(fiber.MethodGet, "/api/admin/user/:id", openapi.Route{
+		Summary: "Get a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersRead,
+		Data: models.Users{},
+	})
+	docs.Add(fiber.MethodPost, "/api/admin/user", openapi.Route{
+		Summary: "Create a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersWrite,
+		Body: handler.RegisterUserInput{},
+	})
+	docs.Add(fiber.MethodPatch, "/api/admin/user/:id", openapi.Route{
+		Summary: "Update a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersWrite,
+		Body:        handler.UpdateUserInput{},
+		Description: "Changing the password logs the user out everywhere. Roles are assigned with PUT /api/admin/user/{id}/role.",
+	})
+	docs.Add(fiber.MethodDelete, "/api/admin/user/:id", openapi.Route{
+		Summary: "Delete a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersWrite,
+	})
+	docs.Add(fiber.MethodPost, "/api/admin/user/:id/revoke-sessions", openapi.Route{
+		Summary: "Revoke every session of a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersWrite,
+	})
+	docs.Add(fiber.MethodPost, "/api/admin/user/:id/unlock", openapi.Route{
+		Summary: "Unlock an account locked after failed logins", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermUsersWrite,
+	})
+	docs.Add(fiber.MethodDelete, "/api/admin/user/:id/mfa", openapi.Route{
+		Summary: "Reset two-factor authentication of a user", Tags: []string{"admin"},
+		Description: "Revokes the API keys of the user as well, they count as a second factor.",
+		Auth:        true, Permission: models.PermUsersWrite,
+	})
+	docs.Add(fiber.MethodPut, "/api/admin/user/:id/role", openapi.Route{
+		Summary: "Assign a role to a user", Tags: []string{"admin"},
+		Auth: true, Permission: models.PermRolesWrite,
+		Body: handler.AssignRoleInput{},
+	})
+
+	docs.Add(fiber.MethodGet, "/api/admin/roles", openapi.Route{
+		Summary: "List roles", Tags: []string{"roles"},
+		Auth: true, Permission: models.PermRolesRead,
+		Data: []models.Roles{},
+	})
+	docs.Add(fiber.MethodPost, "/api/admin/roles", openapi.Route{
+		Summary: "Create a role", Tags: []string{"roles"},
+		Auth: true, Permission: models.PermRolesWrite,
+		Body: handler.CreateRoleInput{}, Status: fiber.StatusCreated, Data: models.Roles{},
+	})
+	docs.Add(fiber.MethodPatch, "/api/admin/roles/:id", openapi.Route{
+		Summary: "Update a role", Tags: []string{"roles"},
+		Auth: true, Permission: models.PermRolesWrite,
+		Body: handler.UpdateRoleInput{}, Data: models.Roles{},
+	})
+	docs.Add(fiber.MethodDelete, "/api/admin/roles/:id", openapi.Route{
+		Summary: "Delete a role", Tags: []string{"roles"},
+		Auth: true, Permission: models.PermRolesWrite,
+		Description: "Built-in roles and roles still assigned to users cannot be deleted.",
+	})
+
+	return docs
+}
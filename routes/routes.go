@@ -2,32 +2,90 @@ package routes
 
 import (
 	"go-task/handler"
+	"go-task/logging"
 	"go-task/middleware"
+	"go-task/models"
+	"go-task/openapi"
+	"go-task/tracing"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func SetupRoutes(app *fiber.App) {
+func SetupRoutes(app *fiber.App, deps handler.Dependencies) {
+	h := handler.New(deps)
+	auth := middleware.NewAuth(deps.Keys, deps.Repos)
+	ownsProduct := middleware.RequireOwnership(auth, deps.Repos.Products.FindByID, "id", models.PermProductsWrite, models.PermProductsWriteAny)
+	ownsAPIKey := middleware.RequireOwnership(auth, deps.Repos.APIKeys.FindByID, "id", "", models.PermUsersWrite)
+	sessionOnly := middleware.RequireSession()
+	adminMFA := middleware.RequireMFA(models.Admin)
+
+	limits := deps.Config.RateLimit
+	limitLogin := middleware.RateLimit(deps.Repos.RateLimits, "login", limits.Login)
+	limitRegister := middleware.RateLimit(deps.Repos.RateLimits, "register", limits.Register)
+	limitWrites := middleware.RateLimit(deps.Repos.RateLimits, "writes", limits.Writes)
+	limitPasswordReset := middleware.RateLimit(deps.Repos.RateLimits, "password_reset", limits.PasswordReset)
+	limitVerification := middleware.RateLimit(deps.Repos.RateLimits, "verification", limits.Verification)
+	verified := middleware.RequireVerified(deps.Repos.Users.FindByID)
+
+	app.Use(tracing.Middleware())
+	app.Use(logging.Middleware())
+	app.Use(deps.Metrics.Middleware())
+
+	app.Get("/healthz", h.Healthz)
+	app.Get("/readyz", h.Readyz)
+	app.Get("/metrics", deps.Metrics.Handler())
+	app.Get("/.well-known/jwks.json", h.JWKS)
+	app.Get("/openapi.json", openapi.Handler(app, apiDocs(), apiInfo()))
+	app.Get("/docs", openapi.UI("/openapi.json"))
+
 	api := app.Group("/api")
 
 	// user /me path to show user profile
 	userRoutes := api.Group("/user")
-	userRoutes.Post("/register", handler.RegisterUser)
-	userRoutes.Post("/login", handler.LoginUser)
-	userRoutes.Get("/products", middleware.Protected(), handler.GetUserProducts) // get product based on ownership
+	userRoutes.Post("/register", limitRegister, h.RegisterUser)
+	userRoutes.Post("/login", limitLogin, h.LoginUser)
+	userRoutes.Post("/login/mfa", limitLogin, h.LoginMFA)
+	userRoutes.Post("/refresh", h.RefreshToken)
+	userRoutes.Post("/logout", auth.Protected(), sessionOnly, h.Logout)
+	userRoutes.Post("/password/forgot", limitPasswordReset, h.ForgotPassword)
+	userRoutes.Post("/password/reset", h.ResetPassword)
+	userRoutes.Get("/verify-email", h.VerifyEmail)
+	userRoutes.Post("/verify-email/resend", auth.Protected(), limitVerification, h.ResendVerification)
+	userRoutes.Get("/products", auth.Protected(), h.GetUserProducts) // get product based on ownership
+	userRoutes.Post("/mfa/enroll", auth.Protected(), sessionOnly, h.EnrollMFA)
+	userRoutes.Post("/mfa/confirm", auth.Protected(), sessionOnly, limitLogin, h.ConfirmMFA)
+	userRoutes.Post("/mfa/recovery-codes", auth.Protected(), sessionOnly, limitLogin, h.RegenerateRecoveryCodes)
+	userRoutes.Delete("/mfa", auth.Protected(), sessionOnly, limitLogin, h.DisableMFA)
+
+	// API keys are managed from a session, admins need one opened with a
+	// second factor since their keys count as one.
+	userRoutes.Get("/api-keys", auth.Protected(), sessionOnly, h.GetAPIKeys)
+	userRoutes.Post("/api-keys", auth.Protected(), sessionOnly, adminMFA, h.CreateAPIKey)
+	userRoutes.Patch("/api-keys/:id", auth.Protected(), sessionOnly, adminMFA, ownsAPIKey, h.UpdateAPIKey)
+	userRoutes.Delete("/api-keys/:id", auth.Protected(), sessionOnly, ownsAPIKey, h.RevokeAPIKey)
 
 	productRoutes := api.Group("/products")
-	productRoutes.Get("/", middleware.Protected(), handler.GetAllProducts)
-	productRoutes.Get("/:id", handler.GetProductById)
-	productRoutes.Post("/", middleware.Protected(), handler.CreateProduct)
-	productRoutes.Patch("/:id", middleware.Protected(), handler.UpdateProduct)
-	productRoutes.Delete("/:id", middleware.Protected(), handler.DeleteProductById)
+	productRoutes.Get("/", auth.Protected(), auth.Require(models.PermProductsReadAny), h.GetAllProducts)
+	productRoutes.Get("/search", auth.Protected(), auth.ResolvePermissions(), h.SearchProducts)
+	productRoutes.Get("/:id", h.GetProductById)
+	productRoutes.Post("/", auth.Protected(), auth.RequireScope(models.PermProductsWrite), verified, limitWrites, h.CreateProduct)
+	productRoutes.Patch("/:id", auth.Protected(), limitWrites, ownsProduct, h.UpdateProduct)
+	productRoutes.Delete("/:id", auth.Protected(), limitWrites, ownsProduct, h.DeleteProductById)
 
 	adminRoutes := api.Group("/admin")
-	adminRoutes.Use(middleware.Protected())
-	adminRoutes.Get("/all-user", handler.GetAllUsers)
-	adminRoutes.Get("/user/:id", handler.GetUserById)
-	adminRoutes.Post("/user", handler.RegisterUser)
-	adminRoutes.Patch("/user/:id", handler.UpdateUser)
-	adminRoutes.Delete("/user/:id", handler.DeleteUser)
+	adminRoutes.Use(auth.Protected(), adminMFA)
+	adminRoutes.Get("/all-user", auth.Require(models.PermUsersRead), h.GetAllUsers)
+	adminRoutes.Get("/user/:id", auth.Require(models.PermUsersRead), h.GetUserById)
+	adminRoutes.Post("/user", auth.Require(models.PermUsersWrite), h.RegisterUser)
+	adminRoutes.Patch("/user/:id", auth.Require(models.PermUsersWrite), h.UpdateUser)
+	adminRoutes.Delete("/user/:id", auth.Require(models.PermUsersWrite), h.DeleteUser)
+	adminRoutes.Post("/user/:id/revoke-sessions", auth.Require(models.PermUsersWrite), h.RevokeUserSessions)
+	adminRoutes.Post("/user/:id/unlock", auth.Require(models.PermUsersWrite), h.UnlockUser)
+	adminRoutes.Delete("/user/:id/mfa", auth.Require(models.PermUsersWrite), h.ResetUserMFA)
+	adminRoutes.Put("/user/:id/role", auth.Require(models.PermRolesWrite), h.AssignUserRole)
+
+	adminRoutes.Get("/roles", auth.Require(models.PermRolesRead), h.GetAllRoles)
+	adminRoutes.Post("/roles", auth.Require(models.PermRolesWrite), h.CreateRole)
+	adminRoutes.Patch("/roles/:id", auth.Require(models.PermRolesWrite), h.UpdateRole)
+	adminRoutes.Delete("/roles/:id", auth.Require(models.PermRolesWrite), h.DeleteRole)
 }
@@ -2,38 +2,130 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-task/apperr"
+	"go-task/config"
 	"go-task/database"
+	"go-task/database/migrations"
+	"go-task/handler"
+	"go-task/keyset"
+	"go-task/lifecycle"
+	"go-task/logging"
+	"go-task/mail"
+	"go-task/metrics"
 	"go-task/models"
+	"go-task/openapi"
+	"go-task/repository"
 	"go-task/routes"
+	"go-task/tracing"
 	"go-task/utils"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm/schema"
 )
 
 var app *fiber.App
+var cfg config.Config
+var repos repository.Repositories
+var keys *keyset.Keyset
+var appMetrics *metrics.Metrics
 var authToken string
 var adminAuthToken string
+var adminTOTPSecret string
+var sentMail *mailbox
 
-// TestMain sets up the test environment
+// TestMain sets up the test environment. With a ../.env file or DB_DRIVER
+// set the tests run against that database, for example
+// DB_DRIVER=sqlite DB_NAME=:memory:, otherwise against in-memory repositories.
 func TestMain(m *testing.M) {
-	if err := godotenv.Load("../.env"); err != nil {
-		panic("Failed to load .env file")
+	envErr := godotenv.Load("../.env")
+
+	cfg = config.Default()
+	cfg.Env = config.EnvTest
+	cfg.JWT.Secret = "test-secret"
+
+	appMetrics = metrics.New()
+
+	if envErr == nil || os.Getenv("DB_DRIVER") != "" {
+		var err error
+		if cfg, err = config.Load(); err != nil {
+			panic(err)
+		}
+
+		// Set up test database connection
+		database.ConnectDB(cfg.Database)
+		repos = repository.NewGorm(database.DB)
+
+		if err := database.DB.Use(appMetrics.GormPlugin()); err != nil {
+			panic(err)
+		}
+		if err := database.DB.Use(tracing.GormPlugin()); err != nil {
+			panic(err)
+		}
+	} else {
+		repos = repository.NewMemory()
 	}
 
-	// Set up test database connection
-	database.ConnectDB()
+	// The suite logs in and registers far more often than a client would,
+	// TestRateLimit covers the limits on an app of its own.
+	cfg.RateLimit.Login.Limit = 0
+	cfg.RateLimit.Register.Limit = 0
+	cfg.RateLimit.Writes.Limit = 0
+	cfg.RateLimit.PasswordReset.Limit = 0
+	cfg.RateLimit.Verification.Limit = 0
+
+	// Emails go through the real SMTP mailer to a local stand-in.
+	var mailRoots *x509.CertPool
+	sentMail, cfg.Mail, mailRoots = startMailbox()
+	mailer := mail.NewSMTP(cfg.Mail.From, cfg.Mail.SMTP, &tls.Config{RootCAs: mailRoots})
+
+	keys = keyset.New(cfg.JWT, repos.SigningKeys)
+	if err := keys.Rotate(context.Background()); err != nil {
+		panic(err)
+	}
 
 	// Initialize app for testing
-	app = fiber.New()
-	routes.SetupRoutes(app)
+	app = fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	routes.SetupRoutes(app, handler.Dependencies{
+		Config:    cfg,
+		Repos:     repos,
+		Lifecycle: lifecycle.New(time.Second),
+		Metrics:   appMetrics,
+		Mailer:    mailer,
+		Keys:      keys,
+	})
 
 	// Run tests
 	m.Run()
@@ -41,12 +133,17 @@ func TestMain(m *testing.M) {
 
 // Helper function to make authenticated requests
 func makeAuthenticatedRequest(method, url string, body io.Reader) (*http.Response, error) {
+	return makeRequestWithToken(method, url, body, authToken)
+}
+
+// Helper function to make requests authenticated with a specific token
+func makeRequestWithToken(method, url string, body io.Reader, token string) (*http.Response, error) {
 	req := httptest.NewRequest(method, url, body)
 	req.Header.Set("Content-Type", "application/json")
 
 	cookie := &http.Cookie{
 		Name:     "_token",
-		Value:    authToken,
+		Value:    token,
 		Path:     "/",
 		MaxAge:   int(utils.JwtExpire),
 		Secure:   false,
@@ -55,15 +152,38 @@ func makeAuthenticatedRequest(method, url string, body io.Reader) (*http.Respons
 	}
 	req.AddCookie(cookie)
 
-	return app.Test(req)
+	return app.Test(req, -1)
+}
+
+// Helper function to parse an ID returned by the API
+func parseID(t *testing.T, id string) uint {
+	parsed, err := strconv.ParseUint(id, 10, 32)
+	assert.NoError(t, err)
+	return uint(parsed)
+}
+
+// Helper function to log in with email and password
+func login(email, password string) (*http.Response, error) {
+	loginData := map[string]string{
+		"email":    email,
+		"password": password,
+	}
+
+	jsonData, _ := json.Marshal(loginData)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	return app.Test(req, -1)
 }
 
 // Test user registration
 func TestRegisterUser(t *testing.T) {
-	CleanupDatabase(database.DB)
-	fmt.Println("Database cleanup successfully.")
+	if database.DB != nil {
+		CleanupDatabase(database.DB)
+		fmt.Println("Database cleanup successfully.")
+	}
 
-	var admin models.Role = "admin"
 	var lastName = "dummy"
 
 	userData := models.Users{
@@ -72,7 +192,6 @@ func TestRegisterUser(t *testing.T) {
 		Password:  "password12345678",
 		FirstName: "new",
 		LastName:  &lastName,
-		Role:      (*models.Role)(&admin),
 	}
 
 	jsonData, _ := json.Marshal(userData)
@@ -80,9 +199,44 @@ func TestRegisterUser(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := app.Test(req)
+	resp, err := app.Test(req, -1)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	verifyEmail(t, "janeDoe@example.com")
+
+	// Registration never grants a role, even when asked to
+	jsonData, _ = json.Marshal(map[string]string{
+		"username":  "wannabe",
+		"email":     "wannabe@example.com",
+		"password":  "password12345678",
+		"firstName": "Wanna",
+		"role":      "admin",
+	})
+	req = httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	user, err := repos.Users.FindByUsername(context.Background(), "wannabe")
 	assert.NoError(t, err)
+	assert.Equal(t, models.User, *user.Role)
+}
+
+// Helper function to open the verification link emailed to a new user
+func verifyEmail(t *testing.T, email string) {
+	msg, ok := sentMail.next(email, "Verify", 5*time.Second)
+	if !assert.True(t, ok, "no verification email sent to %s", email) {
+		return
+	}
+
+	link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(msg.Body))
+	require.NoError(t, err)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, link.RequestURI(), nil), -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
@@ -98,9 +252,9 @@ func TestLoginUser(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := app.Test(req)
+	resp, err := app.Test(req, -1)
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	var result map[string]interface{}
@@ -114,20 +268,113 @@ func TestLoginUser(t *testing.T) {
 	assert.Contains(t, result, "data")
 }
 
+// Helper function to read a cookie set by a response
+func responseCookie(resp *http.Response, name string) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Helper function to call the refresh endpoint with a refresh token cookie
+func refreshWith(refreshToken string) (*http.Response, error) {
+	req := httptest.NewRequest(http.MethodPost, "/api/user/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: utils.RefreshTokenCookie, Value: refreshToken})
+	return app.Test(req, -1)
+}
+
+// Test refresh token rotation
+func TestRefreshToken(t *testing.T) {
+	resp, err := login("janeDoe@example.com", "password12345678")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	refreshToken := responseCookie(resp, utils.RefreshTokenCookie)
+	assert.NotEmpty(t, refreshToken)
+
+	resp, err = refreshWith(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	rotated := responseCookie(resp, utils.RefreshTokenCookie)
+	assert.NotEmpty(t, rotated)
+	assert.NotEqual(t, refreshToken, rotated)
+	assert.NotEmpty(t, responseCookie(resp, utils.AccessTokenCookie))
+
+	resp, err = refreshWith(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// Test reusing a rotated refresh token revokes the whole family
+func TestRefreshTokenReuse(t *testing.T) {
+	resp, err := login("janeDoe@example.com", "password12345678")
+	require.NoError(t, err)
+
+	original := responseCookie(resp, utils.RefreshTokenCookie)
+
+	resp, err = refreshWith(original)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	rotated := responseCookie(resp, utils.RefreshTokenCookie)
+
+	// Presenting the already rotated token again is treated as theft
+	resp, err = refreshWith(original)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The legitimate successor is revoked together with its family
+	resp, err = refreshWith(rotated)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = refreshWith("")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// Test logout revokes the access token and the refresh token
+func TestLogout(t *testing.T) {
+	resp, err := login("janeDoe@example.com", "password12345678")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	accessToken := responseCookie(resp, utils.AccessTokenCookie)
+	refreshToken := responseCookie(resp, utils.RefreshTokenCookie)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/logout", nil)
+	req.AddCookie(&http.Cookie{Name: utils.AccessTokenCookie, Value: accessToken})
+	req.AddCookie(&http.Cookie{Name: utils.RefreshTokenCookie, Value: refreshToken})
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = refreshWith(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 // Test getting user products
 func TestGetUserProducts(t *testing.T) {
 	resp, err := makeAuthenticatedRequest(http.MethodGet, "/api/user/products", nil)
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
-// Test getting all products
+// Test listing every product needs products:read:any, which registered
+// users do not have
 func TestGetAllProducts(t *testing.T) {
 	resp, err := makeAuthenticatedRequest(http.MethodGet, "/api/products", nil)
 
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
 }
 
 // Test getting product by ID
@@ -143,7 +390,7 @@ func TestGetProductById(t *testing.T) {
 
 	// Create a product first
 	createResp, err := makeAuthenticatedRequest(http.MethodPost, "/api/products", bytes.NewReader(jsonData))
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	var createResult map[string]interface{}
 	createBody, _ := io.ReadAll(createResp.Body)
@@ -158,10 +405,18 @@ func TestGetProductById(t *testing.T) {
 	productID := fmt.Sprintf("%v", data["Id"])
 
 	// Now test getting that product
-	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/products/"+productID, nil))
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/products/"+productID, nil), -1)
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/products/abc", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/products/999999", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
 // Test creating a product
@@ -176,7 +431,7 @@ func TestCreateProduct(t *testing.T) {
 
 	resp, err := makeAuthenticatedRequest(http.MethodPost, "/api/products", bytes.NewReader(jsonData))
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
 }
 
@@ -192,7 +447,7 @@ func TestUpdateProduct(t *testing.T) {
 	jsonData, _ := json.Marshal(productData)
 
 	createResp, err := makeAuthenticatedRequest(http.MethodPost, "/api/products", bytes.NewReader(jsonData))
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	// fmt.Println("Create response:", createResp.StatusCode)
 
@@ -209,18 +464,26 @@ func TestUpdateProduct(t *testing.T) {
 	productID := fmt.Sprintf("%v", data["Id"])
 
 	// Now update the product
-	updateData := models.Products{
-		Name:     "Test Product Dummy Edit",
-		Quantity: 100,
-		Price:    100,
+	updateData := map[string]interface{}{
+		"name":     "Test Product Dummy Edit",
+		"quantity": 100,
+		"price":    100,
 	}
 
 	jsonUpdateData, _ := json.Marshal(updateData)
 
 	resp, err := makeAuthenticatedRequest(http.MethodPatch, "/api/products/"+productID, bytes.NewReader(jsonUpdateData))
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Updates follow the rules of creation
+	for _, invalid := range []map[string]interface{}{{"name": "ab"}, {"quantity": -5}, {"quantity": 0}, {"price": 0}} {
+		jsonUpdateData, _ = json.Marshal(invalid)
+		resp, err = makeAuthenticatedRequest(http.MethodPatch, "/api/products/"+productID, bytes.NewReader(jsonUpdateData))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, invalid)
+	}
 }
 
 // Test deleting a product
@@ -235,7 +498,7 @@ func TestDeleteProductById(t *testing.T) {
 	jsonData, _ := json.Marshal(productData)
 
 	createResp, err := makeAuthenticatedRequest(http.MethodPost, "/api/products", bytes.NewReader(jsonData))
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	var createResult map[string]interface{}
 	createBody, _ := io.ReadAll(createResp.Body)
@@ -252,52 +515,56 @@ func TestDeleteProductById(t *testing.T) {
 	// Now delete the product
 	resp, err := makeAuthenticatedRequest(http.MethodDelete, "/api/products/"+productID, nil)
 
-	assert.NoError(t, err)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	// Verify it's deleted by trying to get it
-	getResp, _ := app.Test(httptest.NewRequest(http.MethodGet, "/api/products/"+productID, nil))
+	getResp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/products/"+productID, nil), -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusNotFound, getResp.StatusCode)
 }
 
 func TestSetupTestUsers(t *testing.T) {
-	// Create admin user
-	adminRole := models.Role("admin")
+	// Create admin user, registration only grants the user role
 	adminUser := models.Users{
 		Username:  "adminuser",
 		Email:     "admin@example.com",
 		Password:  "password12345678",
 		FirstName: "Admin",
-		Role:      &adminRole,
 	}
 
 	jsonData, _ := json.Marshal(adminUser)
 	req := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := app.Test(req)
-	assert.NoError(t, err)
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	admin, err := repos.Users.FindByUsername(context.Background(), "adminuser")
+	assert.NoError(t, err)
+	assert.NoError(t, repos.Users.UpdateRole(context.Background(), admin.Id, models.Admin))
+
 	var lastName = "dummy"
 
 	// Create regular user
-	userRole := models.Role("user")
 	regularUser := models.Users{
 		Username:  "regularuser",
 		Email:     "user@example.com",
 		Password:  "password12345678",
 		FirstName: "Regular",
 		LastName:  &lastName,
-		Role:      &userRole,
 	}
 
 	jsonData, _ = json.Marshal(regularUser)
 	req = httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	resp, err = app.Test(req)
-	assert.NoError(t, err)
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	verifyEmail(t, "admin@example.com")
+	verifyEmail(t, "user@example.com")
+
 	// Login as admin
 	loginData := map[string]string{
 		"email":    "admin@example.com",
@@ -306,8 +573,8 @@ func TestSetupTestUsers(t *testing.T) {
 	jsonData, _ = json.Marshal(loginData)
 	req = httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	resp, err = app.Test(req)
-	assert.NoError(t, err)
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	var result map[string]interface{}
@@ -318,6 +585,15 @@ func TestSetupTestUsers(t *testing.T) {
 		adminAuthToken = data
 	}
 
+	// Admin routes need a session opened with a second factor
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	var confirmation handler.MFAConfirmation
+	adminTOTPSecret, confirmation = enableMFA(t, adminAuthToken)
+	adminAuthToken = confirmation.Token
+
 	// Login as regular user
 	loginData = map[string]string{
 		"email":    "user@example.com",
@@ -326,8 +602,8 @@ func TestSetupTestUsers(t *testing.T) {
 	jsonData, _ = json.Marshal(loginData)
 	req = httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(jsonData))
 	req.Header.Set("Content-Type", "application/json")
-	resp, err = app.Test(req)
-	assert.NoError(t, err)
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	body, _ = io.ReadAll(resp.Body)
@@ -362,8 +638,2411 @@ func TestAdminRoutesNoAuth(t *testing.T) {
 			}
 
 			req := httptest.NewRequest(method, route, nil)
-			resp, _ := app.Test(req)
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
 			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, fmt.Sprintf("Route %s with method %s should require auth", route, method))
 		}
 	}
 }
+
+// Test an admin revoking every session of another user
+func TestAdminRevokeUserSessions(t *testing.T) {
+	resp, err := login("user@example.com", "password12345678")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	userToken := responseCookie(resp, utils.AccessTokenCookie)
+
+	user, err := repos.Users.FindByEmail(context.Background(), "user@example.com")
+	assert.NoError(t, err)
+
+	url := fmt.Sprintf("/api/admin/user/%d/revoke-sessions", user.Id)
+
+	resp, err = makeRequestWithToken(http.MethodPost, url, nil, userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodPost, url, nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+// Test admins can only change profile fields and new passwords are hashed
+func TestAdminUpdateUser(t *testing.T) {
+	resp := registerUser(t, app, "patchme", "patchme@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	user, err := repos.Users.FindByEmail(context.Background(), "patchme@example.com")
+	assert.NoError(t, err)
+	url := fmt.Sprintf("/api/admin/user/%d", user.Id)
+	resp = loginAs(t, app, "patchme", "password12345678")
+	key := createAPIKey(t, responseCookie(resp, utils.AccessTokenCookie), handler.CreateAPIKeyInput{Name: "script"})
+
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"role":          "admin",
+		"password":      "newpassword12345",
+		"failed_logins": 100,
+	})
+	resp, err = makeRequestWithToken(http.MethodPatch, url, bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	updated, err := repos.Users.FindByID(context.Background(), user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, models.User, *updated.Role)
+	assert.Equal(t, user.FailedLogins, updated.FailedLogins)
+
+	resp = loginAs(t, app, "patchme", "newpassword12345")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/user/products", nil, key.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "a new password revokes the API keys")
+
+	jsonData, _ = json.Marshal(map[string]string{"email": "user@example.com"})
+	resp, err = makeRequestWithToken(http.MethodPatch, url, bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	jsonData, _ = json.Marshal(map[string]string{"email": "not-an-email"})
+	resp, err = makeRequestWithToken(http.MethodPatch, url, bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// A new email address has to be verified again
+	verifyEmail(t, "patchme@example.com")
+	jsonData, _ = json.Marshal(map[string]string{"email": "patched@example.com"})
+	resp, err = makeRequestWithToken(http.MethodPatch, url, bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	updated, err = repos.Users.FindByID(context.Background(), user.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, updated.VerifiedAt)
+	verifyEmail(t, "patched@example.com")
+	updated, err = repos.Users.FindByID(context.Background(), user.Id)
+	assert.NoError(t, err)
+	assert.NotNil(t, updated.VerifiedAt)
+}
+
+// Test creating a custom role and granting it to a user
+func TestRoleManagement(t *testing.T) {
+	roleData := map[string]interface{}{
+		"name":        "auditor",
+		"description": "Read-only access to users",
+		"permissions": []string{models.PermUsersRead},
+	}
+	jsonData, _ := json.Marshal(roleData)
+
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/admin/roles", bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	roleData["name"] = "bogus"
+	roleData["permissions"] = []string{"users:fly"}
+	jsonData, _ = json.Marshal(roleData)
+
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/admin/roles", bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	user, err := repos.Users.FindByEmail(context.Background(), "user@example.com")
+	assert.NoError(t, err)
+
+	resp, err = login("user@example.com", "password12345678")
+	require.NoError(t, err)
+	userToken := responseCookie(resp, utils.AccessTokenCookie)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	jsonData, _ = json.Marshal(map[string]string{"role": "auditor"})
+	url := fmt.Sprintf("/api/admin/user/%d/role", user.Id)
+
+	resp, err = makeRequestWithToken(http.MethodPut, url, bytes.NewReader(jsonData), userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodPut, url, bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = login("user@example.com", "password12345678")
+	require.NoError(t, err)
+	auditorToken := responseCookie(resp, utils.AccessTokenCookie)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, auditorToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodDelete, fmt.Sprintf("/api/admin/user/%d", user.Id), nil, auditorToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products", nil, auditorToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Ids are checked like ownership checks them
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/admin/user/%s"},
+		{http.MethodPost, "/api/admin/user/%s/unlock"},
+		{http.MethodDelete, "/api/admin/roles/%s"},
+	} {
+		for id, status := range map[string]int{"abc": http.StatusBadRequest, "999999": http.StatusNotFound} {
+			resp, err = makeRequestWithToken(route.method, fmt.Sprintf(route.path, id), nil, adminAuthToken)
+			require.NoError(t, err)
+			assert.Equal(t, status, resp.StatusCode, route.path, id)
+		}
+	}
+}
+
+// Test admins cannot grant or take over more than they have themselves
+func TestAdminEscalation(t *testing.T) {
+	ctx := context.Background()
+	jsonData, _ := json.Marshal(map[string]interface{}{
+		"name":        "helpdesk",
+		"permissions": []string{models.PermUsersRead, models.PermUsersWrite, models.PermRolesWrite},
+	})
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/admin/roles", bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	role, err := repos.Roles.FindByName(ctx, "helpdesk")
+	require.NoError(t, err)
+
+	resp = registerUser(t, app, "helpdesk", "helpdesk@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	helpdesk, err := repos.Users.FindByUsername(ctx, "helpdesk")
+	require.NoError(t, err)
+	require.NoError(t, repos.Users.UpdateRole(ctx, helpdesk.Id, models.Role("helpdesk")))
+	resp = loginAs(t, app, "helpdesk", "password12345678")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	resp = registerUser(t, app, "helpee", "helpee@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	helpee, err := repos.Users.FindByUsername(ctx, "helpee")
+	require.NoError(t, err)
+	admin, err := repos.Users.FindByUsername(ctx, "adminuser")
+	require.NoError(t, err)
+
+	// Users with fewer permissions can still be managed
+	jsonData, _ = json.Marshal(map[string]string{"firstName": "Helped"})
+	resp, err = makeRequestWithToken(http.MethodPatch, fmt.Sprintf("/api/admin/user/%d", helpee.Id), bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// But not an admin
+	jsonData, _ = json.Marshal(map[string]string{"password": "takeover12345678"})
+	resp, err = makeRequestWithToken(http.MethodPatch, fmt.Sprintf("/api/admin/user/%d", admin.Id), bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodDelete, fmt.Sprintf("/api/admin/user/%d", admin.Id), nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	jsonData, _ = json.Marshal(map[string]string{"role": "user"})
+	resp, err = makeRequestWithToken(http.MethodPut, fmt.Sprintf("/api/admin/user/%d/role", admin.Id), bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Nor can roles be assigned, created or widened beyond the caller's own
+	for _, target := range []uint{helpdesk.Id, helpee.Id} {
+		jsonData, _ = json.Marshal(map[string]string{"role": "admin"})
+		resp, err = makeRequestWithToken(http.MethodPut, fmt.Sprintf("/api/admin/user/%d/role", target), bytes.NewReader(jsonData), token)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	jsonData, _ = json.Marshal(map[string]interface{}{
+		"name":        "stocker",
+		"permissions": []string{models.PermProductsWriteAny},
+	})
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/admin/roles", bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	jsonData, _ = json.Marshal(map[string]interface{}{
+		"permissions": []string{models.PermUsersRead, models.PermUsersWrite, models.PermRolesWrite, models.PermRolesRead},
+	})
+	resp, err = makeRequestWithToken(http.MethodPatch, fmt.Sprintf("/api/admin/roles/%d", role.Id), bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	jsonData, _ = json.Marshal(map[string]string{"role": "support"})
+	resp, err = makeRequestWithToken(http.MethodPut, fmt.Sprintf("/api/admin/user/%d/role", helpee.Id), bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	updated, err := repos.Users.FindByID(ctx, helpdesk.Id)
+	require.NoError(t, err)
+	assert.Equal(t, models.Role("helpdesk"), *updated.Role)
+	permissions, err := repos.Roles.Permissions(ctx, models.Role("helpdesk"))
+	require.NoError(t, err)
+	assert.NotContains(t, permissions, models.PermRolesRead)
+}
+
+// Helper function to create a product as the owner of token
+func createProductWithToken(t *testing.T, token string) string {
+	return createNamedProduct(t, token, "Owned Product", 12.5)
+}
+
+// Helper function to create a product with a given name and price
+func createNamedProduct(t *testing.T, token, name string, price float64) string {
+	productData := models.Products{
+		Name:     name,
+		Quantity: 5,
+		Price:    price,
+	}
+
+	jsonData, _ := json.Marshal(productData)
+
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/products", bytes.NewReader(jsonData), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var result map[string]interface{}
+	body, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(body, &result)
+
+	data, ok := result["data"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Failed to parse data field from response")
+	}
+
+	return fmt.Sprintf("%v", data["Id"])
+}
+
+// Test only owners or users with products:write:any may modify a product
+func TestProductOwnership(t *testing.T) {
+	resp, err := login("user@example.com", "password12345678")
+	require.NoError(t, err)
+	userToken := responseCookie(resp, utils.AccessTokenCookie)
+
+	adminProductID := createProductWithToken(t, adminAuthToken)
+	userProductID := createProductWithToken(t, userToken)
+
+	update, _ := json.Marshal(map[string]interface{}{"name": "Hijacked"})
+
+	// Someone else's product is forbidden
+	resp, err = makeRequestWithToken(http.MethodPatch, "/api/products/"+adminProductID, bytes.NewReader(update), userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/products/"+adminProductID, nil, userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	product, err := repos.Products.FindByID(context.Background(), parseID(t, adminProductID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Owned Product", product.Name)
+
+	// Missing products are reported as such
+	resp, err = makeRequestWithToken(http.MethodPatch, "/api/products/999999", bytes.NewReader(update), userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/products/999999", nil, userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// The owner may modify their own product
+	update, _ = json.Marshal(map[string]interface{}{"name": "Renamed"})
+	resp, err = makeRequestWithToken(http.MethodPatch, "/api/products/"+userProductID, bytes.NewReader(update), userToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	renamed, err := repos.Products.FindByID(context.Background(), parseID(t, userProductID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", renamed.Name)
+
+	// Admins may modify any product
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/products/"+userProductID, nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/products/"+adminProductID, nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// Helper function to decode a list response
+func decodeList(t *testing.T, resp *http.Response) ([]map[string]interface{}, map[string]interface{}) {
+	var result struct {
+		Data []map[string]interface{} `json:"data"`
+		Meta map[string]interface{}   `json:"meta"`
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Failed to parse list response: %v", err)
+	}
+
+	return result.Data, result.Meta
+}
+
+// Test paginating, sorting and filtering the product list
+func TestProductListPagination(t *testing.T) {
+	for i, price := range []float64{30, 10, 50, 20, 40} {
+		createNamedProduct(t, adminAuthToken, fmt.Sprintf("Paged %d", i), price)
+	}
+
+	// Walk every page with a cursor
+	prices := []float64{}
+	url := "/api/products?limit=2&sort=-price&name_contains=paged"
+	for page := 0; page < 3; page++ {
+		resp, err := makeRequestWithToken(http.MethodGet, url, nil, adminAuthToken)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		data, meta := decodeList(t, resp)
+		assert.Equal(t, float64(5), meta["total"])
+		for _, item := range data {
+			prices = append(prices, item["Price"].(float64))
+		}
+
+		cursor, _ := meta["nextCursor"].(string)
+		if page == 2 {
+			assert.Empty(t, cursor)
+			break
+		}
+		assert.NotEmpty(t, cursor)
+		url = "/api/products?limit=2&sort=-price&name_contains=paged&cursor=" + cursor
+	}
+	assert.Equal(t, []float64{50, 40, 30, 20, 10}, prices)
+
+	// Offset pagination
+	resp, err := makeRequestWithToken(http.MethodGet, "/api/products?limit=2&offset=4&sort=price&name_contains=paged", nil, adminAuthToken)
+	require.NoError(t, err)
+	data, _ := decodeList(t, resp)
+	assert.Len(t, data, 1)
+	assert.Equal(t, float64(50), data[0]["Price"])
+
+	// Price range filters
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products?name_contains=paged&min_price=20&max_price=40", nil, adminAuthToken)
+	require.NoError(t, err)
+	_, meta := decodeList(t, resp)
+	assert.Equal(t, float64(3), meta["total"])
+
+	// Invalid parameters are rejected
+	for _, query := range []string{"sort=password", "limit=0", "min_price=cheap", "cursor=garbage", "created_after=yesterday"} {
+		resp, err = makeRequestWithToken(http.MethodGet, "/api/products?"+query, nil, adminAuthToken)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	// The user list shares the same query layer
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user?limit=1&sort=username", nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data, meta = decodeList(t, resp)
+	assert.Len(t, data, 1)
+	assert.NotEmpty(t, meta["nextCursor"])
+}
+
+// Test full-text product search with trigram fallback
+func TestSearchProducts(t *testing.T) {
+	productData := map[string]interface{}{
+		"name":        "Wireless Keyboard",
+		"description": "Mechanical switches with bluetooth pairing",
+		"quantity":    3,
+		"price":       75,
+	}
+	jsonData, _ := json.Marshal(productData)
+
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/products", bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q=bluetooth+keyboard", nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, meta := decodeList(t, resp)
+	assert.Equal(t, "fulltext", meta["mode"])
+	if assert.NotEmpty(t, data) {
+		assert.Equal(t, "Wireless Keyboard", data[0]["Name"])
+		assert.Contains(t, data[0]["snippet"], "<mark>")
+	}
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q=keybord", nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, meta = decodeList(t, resp)
+	assert.Equal(t, "fuzzy", meta["mode"])
+	if assert.NotEmpty(t, data) {
+		assert.Equal(t, "Wireless Keyboard", data[0]["Name"])
+	}
+
+	// Snippets are escaped, only the highlighting is markup
+	productData = map[string]interface{}{
+		"name":        "Trackball",
+		"description": "<img src=x onerror=alert(1)> ergonomic trackball",
+		"quantity":    1,
+		"price":       40,
+	}
+	jsonData, _ = json.Marshal(productData)
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/products", bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, q := range []string{"ergonomic", "ergonomik"} {
+		resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q="+q, nil, adminAuthToken)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		data, _ = decodeList(t, resp)
+		if assert.NotEmpty(t, data, q) {
+			assert.NotContains(t, data[0]["snippet"], "<img", q)
+		}
+	}
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q=ergonomic", nil, adminAuthToken)
+	require.NoError(t, err)
+	data, _ = decodeList(t, resp)
+	if assert.NotEmpty(t, data) {
+		assert.Contains(t, data[0]["snippet"], "&lt;img")
+		assert.Contains(t, data[0]["snippet"], "<mark>ergonomic</mark>")
+	}
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q=", nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, limit := range []string{"0", "101", "ten"} {
+		resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q=keyboard&limit="+limit, nil, adminAuthToken)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, limit)
+	}
+
+	// Users without products:read:any only search their own products
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "scopeless", "scopeless@example.com").StatusCode)
+	verifyEmail(t, "scopeless@example.com")
+	resp = loginAs(t, app, "scopeless", "password12345678")
+	userToken := responseCookie(resp, utils.AccessTokenCookie)
+	ownID := createNamedProduct(t, userToken, "Travel Keyboard", 30)
+
+	for _, q := range []string{"keyboard", "keybord"} {
+		resp, err = makeRequestWithToken(http.MethodGet, "/api/products/search?q="+q, nil, userToken)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		data, _ = decodeList(t, resp)
+		if assert.Len(t, data, 1, q) {
+			assert.Equal(t, ownID, fmt.Sprintf("%v", data[0]["Id"]))
+		}
+	}
+}
+
+// Test the embedded migrations are complete and ordered
+func TestLoadMigrations(t *testing.T) {
+	for dir, fsys := range migrationSets {
+		all, err := database.LoadMigrations(fsys, dir)
+		assert.NoError(t, err)
+
+		for i, m := range all {
+			assert.Equal(t, int64(i+1), m.Version, "%s migration versions must be sequential", dir)
+			assert.NotEmpty(t, m.Up)
+			assert.NotEmpty(t, m.Down)
+		}
+	}
+
+	postgres, _ := database.LoadMigrations(migrations.Postgres, "postgres")
+	sqlite, _ := database.LoadMigrations(migrations.SQLite, "sqlite")
+	if assert.Equal(t, len(postgres), len(sqlite), "every dialect needs the same migrations") {
+		for i := range postgres {
+			assert.Equal(t, postgres[i].Name, sqlite[i].Name)
+		}
+	}
+
+	missingDown := fstest.MapFS{
+		"sql/0001_init.up.sql": {Data: []byte("SELECT 1;")},
+	}
+	_, err := database.LoadMigrations(missingDown, "sql")
+	assert.Error(t, err)
+
+	badName := fstest.MapFS{
+		"sql/init.up.sql": {Data: []byte("SELECT 1;")},
+	}
+	_, err = database.LoadMigrations(badName, "sql")
+	assert.Error(t, err)
+}
+
+var migrationSets = map[string]fs.FS{
+	"postgres": migrations.Postgres,
+	"sqlite":   migrations.SQLite,
+}
+
+// Test every model column is created by a migration
+func TestMigrationsCoverModels(t *testing.T) {
+	for dir, fsys := range migrationSets {
+		all, err := database.LoadMigrations(fsys, dir)
+		assert.NoError(t, err)
+
+		var up strings.Builder
+		for _, m := range all {
+			up.WriteString(m.Up)
+		}
+		sql := up.String()
+
+		tables := []interface{}{
+			&models.Users{},
+			&models.Products{},
+			&models.RefreshTokens{},
+			&models.RevokedTokens{},
+			&models.Roles{},
+			&models.RolePermissions{},
+			&models.RateLimits{},
+			&models.PasswordResetTokens{},
+			&models.RecoveryCodes{},
+			&models.APIKeys{},
+			&models.APIKeyScopes{},
+			&models.SigningKeys{},
+		}
+
+		for _, table := range tables {
+			s, err := schema.Parse(table, &sync.Map{}, schema.NamingStrategy{})
+			assert.NoError(t, err)
+
+			assert.Contains(t, sql, "CREATE TABLE IF NOT EXISTS "+s.Table+" (")
+			for _, field := range s.Fields {
+				if field.DBName == "" {
+					continue
+				}
+				pattern := regexp.MustCompile(`\b` + field.DBName + `\s+\w+`)
+				assert.Regexp(t, pattern, sql, "%s column %s.%s has no migration", dir, s.Table, field.DBName)
+			}
+		}
+	}
+}
+
+// Test the SQLite migrations apply and roll back cleanly and that unique
+// violations are reported as typed errors
+func TestSQLiteMigrations(t *testing.T) {
+	db, err := database.Open(config.Database{Driver: database.DriverSQLite, Name: ":memory:"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	all, _ := database.LoadMigrations(migrations.SQLite, "sqlite")
+
+	applied, err := database.MigrateUp(db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(all), applied)
+
+	states, err := database.MigrationStatus(db)
+	assert.NoError(t, err)
+	for _, state := range states {
+		assert.NotNil(t, state.AppliedAt, "migration %d not applied", state.Version)
+	}
+
+	users := repository.NewGorm(db).Users
+	user := models.Users{Username: "first", Email: "dup@example.com", Password: "x", FirstName: "First"}
+	assert.NoError(t, users.Create(context.Background(), &user))
+
+	user = models.Users{Username: "second", Email: "dup@example.com", Password: "x", FirstName: "Second"}
+	assert.ErrorIs(t, users.Create(context.Background(), &user), repository.ErrDuplicateEmail)
+
+	user = models.Users{Username: "first", Email: "other@example.com", Password: "x", FirstName: "Third"}
+	assert.ErrorIs(t, users.Create(context.Background(), &user), repository.ErrDuplicateUsername)
+
+	rolledBack, err := database.MigrateDown(db, len(all))
+	assert.NoError(t, err)
+	assert.Equal(t, len(all), rolledBack)
+}
+
+// Test configuration precedence and validation
+func TestLoadConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, key := range []string{"APP_ENV", "APP_PORT", "CONFIG_FILE", "DB_DRIVER", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_AUTO_MIGRATE", "JWT_SECRET", "JWT_ALGORITHM", "JWT_ACCEPT_HS256", "JWT_ROTATION_INTERVAL", "JWT_ROTATION_OVERLAP", "LOG_LEVEL", "LOG_FORMAT", "TRACING_EXPORTER", "TRACING_FILE", "RATE_LIMIT_LOGIN", "LOGIN_LOCKOUT_THRESHOLD", "MAIL_DRIVER", "MAIL_FILE", "PASSWORD_RESET_TTL"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	yamlFile := "port: 8080\ndatabase:\n  driver: sqlite\n  name: from-file.db\n"
+	assert.NoError(t, os.WriteFile("config.yaml", []byte(yamlFile), 0o600))
+	assert.NoError(t, os.WriteFile(".env", []byte("DB_NAME=from-dotenv.db\nAPP_PORT=8081\n"), 0o600))
+	t.Setenv("APP_PORT", "9090")
+
+	cfg, err := config.Load()
+	if assert.NoError(t, err) {
+		assert.Equal(t, ":9090", cfg.Addr(), "environment overrides .env and the file")
+		assert.Equal(t, "from-dotenv.db", cfg.Database.Name, ".env overrides the file")
+		assert.Equal(t, database.DriverSQLite, cfg.Database.Driver)
+		assert.True(t, cfg.Database.AutoMigrate)
+		assert.NotEmpty(t, cfg.JWT.Secret, "development falls back to a fixed secret")
+		assert.Equal(t, "info", cfg.Log.Level)
+		assert.Equal(t, config.LogFormatJSON, cfg.Log.Format)
+	}
+	os.Unsetenv("DB_NAME")
+
+	t.Setenv("LOG_LEVEL", "verbose")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Log.Level")
+	os.Unsetenv("LOG_LEVEL")
+
+	t.Setenv("TRACING_EXPORTER", "file")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Tracing.File", "the file exporter needs a path")
+	os.Unsetenv("TRACING_EXPORTER")
+
+	t.Setenv("MAIL_DRIVER", "file")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Mail.File", "the file mailer needs a path")
+	t.Setenv("MAIL_FILE", "mail.mbox")
+	t.Setenv("PASSWORD_RESET_TTL", "1h")
+	mailCfg, err := config.Load()
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.MailFile, mailCfg.Mail.Driver)
+		assert.Equal(t, config.Duration(time.Hour), mailCfg.PasswordReset.TokenTTL)
+	}
+	os.Unsetenv("MAIL_DRIVER")
+	os.Unsetenv("MAIL_FILE")
+	os.Unsetenv("PASSWORD_RESET_TTL")
+
+	t.Setenv("RATE_LIMIT_LOGIN", "3/30s/username")
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "0")
+	limits, err := config.Load()
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.RateLimitRule{Limit: 3, Window: config.Duration(30 * time.Second), Key: config.RateLimitByUsername}, limits.RateLimit.Login)
+		assert.Zero(t, limits.RateLimit.Lockout.Threshold)
+	}
+
+	t.Setenv("RATE_LIMIT_LOGIN", "3/30s/cookie")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Key")
+	t.Setenv("RATE_LIMIT_LOGIN", "3")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "RATE_LIMIT_LOGIN")
+	os.Unsetenv("RATE_LIMIT_LOGIN")
+	os.Unsetenv("LOGIN_LOCKOUT_THRESHOLD")
+
+	t.Setenv("JWT_ALGORITHM", "RS256")
+	t.Setenv("JWT_ACCEPT_HS256", "true")
+	t.Setenv("JWT_ROTATION_OVERLAP", "10m")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "overlap", "retired keys must outlive the tokens they signed")
+	t.Setenv("JWT_ROTATION_OVERLAP", "2h")
+	jwtCfg, err := config.Load()
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.JWTAlgorithmRS256, jwtCfg.JWT.Algorithm)
+		assert.True(t, jwtCfg.JWT.AcceptHS256)
+		assert.Equal(t, config.Duration(2*time.Hour), jwtCfg.JWT.RotationOverlap)
+	}
+	t.Setenv("JWT_ROTATION_INTERVAL", "1h")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "RotationOverlap", "the next key is published within the interval")
+	t.Setenv("JWT_ALGORITHM", "none")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Algorithm")
+	for _, key := range []string{"JWT_ALGORITHM", "JWT_ACCEPT_HS256", "JWT_ROTATION_INTERVAL", "JWT_ROTATION_OVERLAP"} {
+		os.Unsetenv(key)
+	}
+
+	t.Setenv("CONFIG_FILE", "config.toml")
+	assert.NoError(t, os.WriteFile("config.toml", []byte("env = \"production\"\n\n[database]\ndriver = \"sqlite\"\n"), 0o600))
+
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "JWT_SECRET", "production requires a secret")
+
+	t.Setenv("JWT_SECRET", strings.Repeat("x", 32))
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "MAIL_DRIVER", "production needs a mailer that delivers")
+
+	t.Setenv("MAIL_DRIVER", "file")
+	t.Setenv("MAIL_FILE", "mail.mbox")
+	cfg, err = config.Load()
+	if assert.NoError(t, err) {
+		assert.True(t, cfg.IsProduction())
+	}
+
+	t.Setenv("DB_DRIVER", "postgres")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "Host", "postgres needs connection settings")
+
+	t.Setenv("DB_PORT", "not-a-number")
+	_, err = config.Load()
+	assert.ErrorContains(t, err, "DB_PORT")
+}
+
+// Test components start in order and stop in reverse order
+func TestLifecycle(t *testing.T) {
+	var events []string
+	hook := func(name string) lifecycle.Hook {
+		return lifecycle.Hook{
+			Name:  name,
+			Start: func(context.Context) error { events = append(events, "start "+name); return nil },
+			Stop:  func(context.Context) error { events = append(events, "stop "+name); return nil },
+		}
+	}
+
+	lc := lifecycle.New(time.Second)
+	lc.Append(hook("database"))
+	workerStopped := make(chan struct{})
+	lc.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(workerStopped)
+		return nil
+	})
+	lc.Append(hook("http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	assert.NoError(t, lc.Run(ctx))
+	assert.True(t, lc.ShuttingDown())
+	assert.Equal(t, []string{"start database", "start http", "stop http", "stop database"}, events)
+	<-workerStopped
+
+	// A failing start stops what already started
+	events = nil
+	lc = lifecycle.New(time.Second)
+	lc.Append(hook("database"))
+	lc.Append(lifecycle.Hook{Name: "http", Start: func(context.Context) error { return errors.New("port in use") }})
+
+	err := lc.Run(context.Background())
+	assert.ErrorContains(t, err, "start http: port in use")
+	assert.Equal(t, []string{"start database", "stop database"}, events)
+
+	// A failing worker shuts everything down
+	events = nil
+	lc = lifecycle.New(time.Second)
+	lc.Append(hook("database"))
+	lc.Go("worker", func(context.Context) error { return errors.New("lost connection") })
+
+	err = lc.Run(context.Background())
+	assert.ErrorContains(t, err, "worker: lost connection")
+	assert.Equal(t, []string{"start database", "stop database"}, events)
+
+	// Components that do not stop in time are reported
+	lc = lifecycle.New(10 * time.Millisecond)
+	lc.Append(lifecycle.Hook{Name: "slow", Stop: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, lc.Run(ctx), context.DeadlineExceeded)
+}
+
+type failingStatus struct{}
+
+func (failingStatus) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func (failingStatus) SchemaVersion(context.Context) (database.SchemaVersion, error) {
+	return database.SchemaVersion{Current: 5, Latest: 6, Pending: 1}, nil
+}
+
+// Test liveness and readiness endpoints
+func TestHealthEndpoints(t *testing.T) {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Success bool
+		Data    struct {
+			Checks map[string]map[string]interface{}
+			Build  map[string]interface{}
+		}
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.True(t, body.Success)
+	assert.Equal(t, "ok", body.Data.Checks["database"]["status"])
+	assert.Equal(t, "ok", body.Data.Checks["migrations"]["status"])
+	assert.NotEmpty(t, body.Data.Build["version"])
+
+	// A broken database or pending migrations make the instance unready
+	broken := repos
+	broken.Status = failingStatus{}
+	brokenApp := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	routes.SetupRoutes(brokenApp, handler.Dependencies{
+		Config:    cfg,
+		Repos:     broken,
+		Lifecycle: lifecycle.New(time.Second),
+		Metrics:   metrics.New(),
+		Keys:      keys,
+	})
+
+	resp, err = brokenApp.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, map[string]interface{}{"status": "fail"}, body.Data.Checks["database"], "errors stay in the log")
+	assert.Equal(t, map[string]interface{}{"status": "fail"}, body.Data.Checks["migrations"])
+
+	// So does a shutdown in progress, while liveness is unaffected
+	lc := lifecycle.New(time.Second)
+	stoppingApp := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	routes.SetupRoutes(stoppingApp, handler.Dependencies{
+		Config:    cfg,
+		Repos:     repos,
+		Lifecycle: lc,
+		Metrics:   metrics.New(),
+		Keys:      keys,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, lc.Run(ctx))
+
+	resp, err = stoppingApp.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = stoppingApp.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// Test /metrics reports requests per route template and business events
+func TestMetrics(t *testing.T) {
+	productID := createProductWithToken(t, adminAuthToken)
+
+	resp, err := makeRequestWithToken(http.MethodGet, "/api/products/"+productID, nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = login("nobody@example.com", "password123")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/does-not-exist", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	scrape := string(body)
+
+	assert.Contains(t, scrape, `http_requests_total{method="GET",route="/api/products/:id",status="200"}`)
+	assert.Contains(t, scrape, `http_requests_total{method="GET",route="unmatched",status="404"}`)
+	assert.Contains(t, scrape, `http_request_duration_seconds_bucket{method="POST",route="/api/products/"`)
+	assert.NotContains(t, scrape, "/api/products/"+productID+`"`)
+	assert.Contains(t, scrape, `user_login_failures_total{reason="unknown_user"}`)
+	assert.Regexp(t, `(?m)^user_registrations_total [1-9]`, scrape)
+	assert.Regexp(t, `(?m)^user_logins_total [1-9]`, scrape)
+	assert.Regexp(t, `(?m)^products_created_total [1-9]`, scrape)
+
+	if database.DB != nil {
+		assert.Contains(t, scrape, `db_query_duration_seconds_count{operation="create",table="products"}`)
+	}
+}
+
+// Test log records are redacted and carry the request ID
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewHandler(&buf, config.Log{Level: "info", Format: config.LogFormatJSON}))
+
+	type credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Nested   struct {
+			APIToken string
+		}
+		Seen time.Time
+	}
+	input := credentials{Username: "jane", Password: "hunter22"}
+	input.Nested.APIToken = "tok-123"
+	input.Seen = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	logger.Info("login", "input", input, "refresh_token", "tok-456", "headers", map[string]string{"Cookie": "_token=tok-789", "Accept": "text/html"})
+	logger.Debug("hidden below the configured level")
+
+	line := buf.String()
+	assert.NotContains(t, line, "hunter22")
+	assert.NotContains(t, line, "tok-")
+	assert.Contains(t, line, `"username":"jane"`)
+	assert.Contains(t, line, `"password":"[REDACTED]"`)
+	assert.Contains(t, line, `"Accept":"text/html"`)
+	assert.Contains(t, line, `"Seen":"2024-01-02T03:04:05Z"`)
+	assert.NotContains(t, line, "hidden")
+
+	// Requests log through the default logger with their ID
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(previous)
+	buf.Reset()
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(logging.RequestIDHeader, "req-42")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, "req-42", resp.Header.Get(logging.RequestIDHeader))
+	assert.Contains(t, buf.String(), `"request_id":"req-42"`)
+	assert.Contains(t, buf.String(), `"path":"/healthz"`)
+
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(logging.RequestIDHeader, "bad id\n")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Len(t, resp.Header.Get(logging.RequestIDHeader), 36, "malformed IDs are replaced")
+
+	// Passwords sent to the API never reach the log
+	buf.Reset()
+	resp, err = login("admin@example.com", "password12345678")
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Header.Get(logging.RequestIDHeader))
+	assert.Contains(t, buf.String(), `"path":"/api/user/login"`)
+	assert.NotContains(t, buf.String(), "password12345678")
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) string {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// Test requests are traced from the HTTP span down to the JWT check and the
+// database queries, continuing the caller's trace
+func TestTracing(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), config.Tracing{Exporter: config.TracingNone})
+	assert.NoError(t, err)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/products", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.AddCookie(&http.Cookie{Name: "_token", Value: adminAuthToken})
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("traceparent"), "00-"+traceID+"-"), "the trace context is returned")
+
+	spans := recorder.Ended()
+	server := findSpan(spans, "GET /api/user/products")
+	if !assert.NotNil(t, server) {
+		return
+	}
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, parentID, server.Parent().SpanID().String())
+	assert.Equal(t, "/api/user/products", spanAttribute(server, "http.route"))
+	assert.Equal(t, "200", spanAttribute(server, "http.response.status_code"))
+
+	verify := findSpan(spans, "auth.verify_jwt")
+	if assert.NotNil(t, verify) {
+		assert.Equal(t, server.SpanContext().SpanID(), verify.Parent().SpanID())
+		assert.Equal(t, codes.Unset, verify.Status().Code)
+	}
+
+	if database.DB != nil {
+		var queries []sdktrace.ReadOnlySpan
+		for _, span := range spans {
+			if strings.HasPrefix(span.Name(), "gorm.") {
+				queries = append(queries, span)
+			}
+		}
+		assert.NotEmpty(t, queries)
+
+		var handlerQuery sdktrace.ReadOnlySpan
+		for _, query := range queries {
+			assert.NotContains(t, spanAttribute(query, "db.query.text"), "admin@example.com")
+			if query.Parent().SpanID() == server.SpanContext().SpanID() && spanAttribute(query, "db.collection.name") == "products" {
+				handlerQuery = query
+			}
+		}
+		assert.NotNil(t, handlerQuery, "handler queries are children of the request span")
+	}
+
+	// A rejected token fails the verification span
+	recorder = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, "not-a-jwt")
+	require.NoError(t, err)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	if verify := findSpan(recorder.Ended(), "auth.verify_jwt"); assert.NotNil(t, verify) {
+		assert.Equal(t, codes.Error, verify.Status().Code)
+	}
+}
+
+// Test the file exporter writes the spans once tracing is stopped
+func TestTracingFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	stop, err := tracing.Setup(context.Background(), config.Tracing{
+		Exporter:    config.TracingFile,
+		File:        path,
+		SampleRatio: 1,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.Header.Get("traceparent"))
+
+	assert.NoError(t, stop(context.Background()))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"Name":"GET /healthz"`)
+	assert.Contains(t, string(content), `"service.name"`)
+}
+
+func registerUser(t *testing.T, target *fiber.App, username, email string) *http.Response {
+	userData := map[string]string{
+		"username":  username,
+		"email":     email,
+		"password":  "password12345678",
+		"firstName": "Limit",
+	}
+	jsonData, _ := json.Marshal(userData)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := target.Test(req, -1)
+	require.NoError(t, err)
+	return resp
+}
+
+func loginAs(t *testing.T, target *fiber.App, username, password string) *http.Response {
+	jsonData, _ := json.Marshal(map[string]string{"username": username, "password": password})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := target.Test(req, -1)
+	require.NoError(t, err)
+	return resp
+}
+
+// assertWindowSeconds checks a header counts the seconds left of a window
+// of window seconds that started during the test. Slow hashing, as under
+// the race detector, may already have used up a few.
+func assertWindowSeconds(t *testing.T, header string, window int) {
+	seconds, err := strconv.Atoi(header)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, seconds, window)
+	assert.Greater(t, seconds, window-10)
+}
+
+// Test requests over the limit are refused with 429 and the limit headers
+func TestRateLimit(t *testing.T) {
+	limited := cfg
+	limited.RateLimit.Register = config.RateLimitRule{Limit: 2, Window: config.Duration(time.Minute), Key: config.RateLimitByIP}
+	limited.RateLimit.Login = config.RateLimitRule{Limit: 3, Window: config.Duration(time.Minute), Key: config.RateLimitByUsername}
+	limited.RateLimit.Verification = config.RateLimitRule{Limit: 1, Window: config.Duration(time.Hour), Key: config.RateLimitByUser}
+	limited.RateLimit.Lockout.Threshold = 0
+
+	limitedRepos := repos
+	limitedRepos.RateLimits = repository.NewMemoryRateLimits()
+
+	limitedApp := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	routes.SetupRoutes(limitedApp, handler.Dependencies{
+		Config:    limited,
+		Repos:     limitedRepos,
+		Lifecycle: lifecycle.New(time.Second),
+		Metrics:   metrics.New(),
+		Keys:      keys,
+	})
+
+	resp := registerUser(t, limitedApp, "limited1", "limited1@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assertWindowSeconds(t, resp.Header.Get("RateLimit-Reset"), 60)
+
+	resp = registerUser(t, limitedApp, "limited2", "limited2@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = registerUser(t, limitedApp, "limited3", "limited3@example.com")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assertWindowSeconds(t, resp.Header.Get("Retry-After"), 60)
+
+	// Logins are counted per username, other accounts are unaffected
+	for i := 0; i < 3; i++ {
+		resp = loginAs(t, limitedApp, "limited1", "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	resp = loginAs(t, limitedApp, "limited1", "password12345678")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp = loginAs(t, limitedApp, "limited2", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Verification emails are resent at most once an hour
+	token := responseCookie(resp, utils.AccessTokenCookie)
+	for _, status := range []int{http.StatusAccepted, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/verify-email/resend", nil)
+		req.AddCookie(&http.Cookie{Name: utils.AccessTokenCookie, Value: token})
+		resp, err := limitedApp.Test(req, -1)
+		require.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode)
+	}
+}
+
+// Test repeated failed logins lock the account until an admin unlocks it
+func TestLoginLockout(t *testing.T) {
+	resp := registerUser(t, app, "lockme", "lockme@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	threshold := cfg.RateLimit.Lockout.Threshold
+	for i := 0; i < threshold; i++ {
+		resp = loginAs(t, app, "lockme", "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Locked, even with the right password
+	resp = loginAs(t, app, "lockme", "password12345678")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Duration(cfg.RateLimit.Lockout.Duration).Seconds(), retryAfter, 1)
+
+	user, err := repos.Users.FindByUsername(context.Background(), "lockme")
+	assert.NoError(t, err)
+	assert.Equal(t, threshold, user.FailedLogins)
+
+	url := fmt.Sprintf("/api/admin/user/%d/unlock", user.Id)
+	resp, err = makeRequestWithToken(http.MethodPost, url, nil, adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = loginAs(t, app, "lockme", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	user, err = repos.Users.FindByUsername(context.Background(), "lockme")
+	assert.NoError(t, err)
+	assert.Zero(t, user.FailedLogins)
+	assert.Nil(t, user.LockedUntil)
+
+	// The next lock lasts twice as long
+	for i := 0; i < 2*threshold-1; i++ {
+		repos.Users.RecordLoginFailure(context.Background(), user.Id)
+	}
+	resp = loginAs(t, app, "lockme", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	user, err = repos.Users.FindByUsername(context.Background(), "lockme")
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.LockedUntil) {
+		assert.InDelta(t, 2*time.Duration(cfg.RateLimit.Lockout.Duration).Seconds(), time.Until(*user.LockedUntil).Seconds(), 2)
+	}
+}
+
+// Test both rate limit stores count windows the same way
+func TestRateLimitStores(t *testing.T) {
+	stores := map[string]repository.RateLimitRepository{"memory": repository.NewMemoryRateLimits()}
+	if database.DB != nil {
+		stores["database"] = repos.RateLimits
+	}
+
+	for name, store := range stores {
+		ctx := context.Background()
+		bucket := "test:" + name
+
+		hits, resetAt, err := store.Hit(ctx, bucket, time.Minute)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1, hits, name)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), resetAt, 2*time.Second, name)
+
+		hits, second, err := store.Hit(ctx, bucket, time.Minute)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 2, hits, name)
+		assert.WithinDuration(t, resetAt, second, time.Millisecond, "%s: the window does not move", name)
+
+		// An expired window starts over
+		hits, _, err = store.Hit(ctx, bucket+":short", time.Millisecond)
+		assert.NoError(t, err, name)
+		time.Sleep(5 * time.Millisecond)
+		hits, _, err = store.Hit(ctx, bucket+":short", time.Minute)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1, hits, name)
+
+		assert.NoError(t, store.PurgeExpired(ctx, time.Now().Add(2*time.Minute)), name)
+		hits, _, err = store.Hit(ctx, bucket, time.Minute)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1, hits, "%s: purged windows start over", name)
+	}
+}
+
+// Test failures share one envelope, or RFC 7807 problem details on request
+func TestErrorResponses(t *testing.T) {
+	var body struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Error   struct {
+			Code    string `json:"code"`
+			Details any    `json:"details"`
+		} `json:"error"`
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/products/999999", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.False(t, body.Success)
+	assert.Equal(t, "not_found", body.Error.Code)
+	assert.Equal(t, "Product not found.", body.Message)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/does-not-exist", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "not_found", body.Error.Code, "errors raised by Fiber use the envelope too")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"username": "x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "validation_failed", body.Error.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/products/999999?x=1", nil)
+	req.Header.Set("Accept", apperr.MIMEProblemJSON)
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, apperr.MIMEProblemJSON, resp.Header.Get("Content-Type"))
+
+	var problem apperr.Problem
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, apperr.Problem{
+		Type:     "about:blank",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "Product not found.",
+		Instance: "/api/products/999999?x=1",
+		Code:     apperr.KindNotFound,
+	}, problem)
+
+	// Internal errors keep their cause out of the response
+	failing := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	failing.Get("/", func(c *fiber.Ctx) error {
+		return errors.New("connection refused by 10.0.0.3")
+	})
+	resp, err = failing.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	raw, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(raw), "10.0.0.3")
+	assert.Contains(t, string(raw), `"code":"internal"`)
+
+	assert.Equal(t, http.StatusConflict, apperr.Status(fmt.Errorf("create: %w", apperr.Conflict("taken"))))
+	assert.Equal(t, apperr.KindForbidden, apperr.KindOf(apperr.Internal("Failed", apperr.Forbidden("no"))))
+}
+
+// Test validation errors list every invalid field by its JSON name, with
+// messages in the language the client accepts
+func TestValidationErrors(t *testing.T) {
+	type fieldError struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule"`
+		Param   string `json:"param"`
+		Message string `json:"message"`
+	}
+	var body struct {
+		Message string `json:"message"`
+		Error   struct {
+			Code    string       `json:"code"`
+			Details []fieldError `json:"details"`
+		} `json:"error"`
+	}
+
+	createProduct := func(language string) *http.Response {
+		jsonData, _ := json.Marshal(map[string]any{"name": "ab", "quantity": 1})
+		req := httptest.NewRequest(http.MethodPost, "/api/products/", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "_token", Value: adminAuthToken})
+		if language != "" {
+			req.Header.Set("Accept-Language", language)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := createProduct("")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "validation_failed", body.Error.Code)
+	assert.Equal(t, "Some fields are invalid.", body.Message)
+	assert.Equal(t, []fieldError{
+		{Field: "name", Rule: "min", Param: "3", Message: "name must be at least 3 characters in length"},
+		{Field: "price", Rule: "required", Message: "price is a required field"},
+	}, body.Error.Details)
+
+	resp = createProduct("id-ID,id;q=0.9,en;q=0.8")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "Beberapa field tidak valid.", body.Message)
+	if assert.Len(t, body.Error.Details, 2) {
+		assert.Equal(t, "name", body.Error.Details[0].Field)
+		assert.Equal(t, "panjang minimal name adalah 3 karakter", body.Error.Details[0].Message)
+		assert.Equal(t, "price wajib diisi", body.Error.Details[1].Message)
+	}
+
+	// Unsupported languages fall back to English
+	resp = createProduct("fr-FR")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "Some fields are invalid.", body.Message)
+}
+
+// Test the custom validation rules and their messages
+func TestValidationRules(t *testing.T) {
+	ctx := context.Background()
+
+	type input struct {
+		Username string `json:"username" validate:"omitempty,username"`
+		Password string `json:"password" validate:"omitempty,password"`
+		SKU      string `json:"sku" validate:"omitempty,sku"`
+		Currency string `json:"currency" validate:"omitempty,currency"`
+		Email    string `json:"email" validate:"omitempty,unique=test.email"`
+	}
+
+	taken := map[string]bool{"taken@example.com": true}
+	v := utils.NewValidator(map[string]utils.UniqueLookup{
+		"test.email": func(_ context.Context, value string) (bool, error) {
+			return taken[value], nil
+		},
+	})
+
+	cases := []struct {
+		name    string
+		input   input
+		rule    string
+		message string
+	}{
+		{"username charset", input{Username: "john doe"}, "username", "username may only contain letters, digits, '.', '_' and '-', and must start and end with a letter or digit"},
+		{"username edge", input{Username: "_john"}, "username", ""},
+		{"password classes", input{Password: "abcdefghijkl"}, "password", "password is too weak, mix at least 2 of lowercase letters, uppercase letters, digits and symbols and avoid repeating characters"},
+		{"password repeats", input{Password: "aaaa1111aaaa"}, "password", ""},
+		{"sku lowercase", input{SKU: "abc-123"}, "sku", "sku must be groups of 2 to 10 uppercase letters or digits separated by '-', such as ABC-123"},
+		{"sku short group", input{SKU: "ABC-1"}, "sku", ""},
+		{"currency unknown", input{Currency: "XYZ"}, "currency", "currency must be an ISO 4217 currency code such as IDR or USD"},
+		{"currency lowercase", input{Currency: "idr"}, "currency", ""},
+		{"unique taken", input{Email: "taken@example.com"}, "unique", "email is already taken"},
+	}
+
+	for _, tc := range cases {
+		fields, err := v.Validate(ctx, tc.input, "en")
+		assert.NoError(t, err, tc.name)
+		if assert.Len(t, fields, 1, tc.name) {
+			assert.Equal(t, tc.rule, fields[0].Rule, tc.name)
+			if tc.message != "" {
+				assert.Equal(t, tc.message, fields[0].Message, tc.name)
+			}
+		}
+	}
+
+	valid := input{
+		Username: "john.doe-42",
+		Password: "correct horse 7",
+		SKU:      "TSHIRT-RED-XL",
+		Currency: "USD",
+		Email:    "free@example.com",
+	}
+	fields, err := v.Validate(ctx, valid, "en")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+	fields, err = v.Validate(ctx, input{Password: "password12345678"}, "en")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	fields, err = v.Validate(ctx, input{Username: "john doe", Email: "taken@example.com"}, "id")
+	assert.NoError(t, err)
+	if assert.Len(t, fields, 2) {
+		assert.Equal(t, "username hanya boleh berisi huruf, angka, '.', '_' dan '-', serta harus diawali dan diakhiri huruf atau angka", fields[0].Message)
+		assert.Equal(t, "email sudah digunakan", fields[1].Message)
+	}
+
+	// Values that cannot be looked up are an error rather than accepted
+	failing := utils.NewValidator(map[string]utils.UniqueLookup{
+		"test.email": func(context.Context, string) (bool, error) {
+			return false, errors.New("connection refused")
+		},
+	})
+	_, err = failing.Validate(ctx, input{Email: "free@example.com"}, "en")
+	assert.ErrorContains(t, err, "connection refused")
+	_, err = utils.NewValidator(nil).Validate(ctx, input{Email: "free@example.com"}, "en")
+	assert.ErrorContains(t, err, "test.email", "a unique tag without a lookup")
+
+	assert.Error(t, v.RegisterRule(utils.Rule{
+		Tag:      "untranslated",
+		Validate: func(context.Context, validator.FieldLevel) bool { return true },
+		Messages: map[string]string{"en": "{0} is fine"},
+	}), "every rule needs a message in every language")
+}
+
+// Test registration and products check unique values before inserting
+func TestUniqueFields(t *testing.T) {
+	resp := registerUser(t, app, "uniqueuser", "unique@example.com")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Error struct {
+			Code    string             `json:"code"`
+			Details []utils.FieldError `json:"details"`
+		} `json:"error"`
+	}
+
+	resp = registerUser(t, app, "uniqueuser", "unique@example.com")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "validation_failed", body.Error.Code)
+	assert.ElementsMatch(t, []utils.FieldError{
+		{Field: "username", Rule: "unique", Param: "users.username", Message: "username is already taken"},
+		{Field: "email", Rule: "unique", Param: "users.email", Message: "email is already taken"},
+	}, body.Error.Details)
+
+	createWithSKU := func(sku string) *http.Response {
+		jsonData, _ := json.Marshal(map[string]any{"name": "Shirt", "quantity": 1, "price": 10, "sku": sku, "currency": "USD"})
+		resp, err := makeRequestWithToken(http.MethodPost, "/api/products/", bytes.NewReader(jsonData), adminAuthToken)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp = createWithSKU("SHIRT-01")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		Data models.Products `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, "USD", created.Data.Currency)
+
+	resp = createWithSKU("SHIRT-01")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	if assert.Len(t, body.Error.Details, 1) {
+		assert.Equal(t, "sku", body.Error.Details[0].Field)
+	}
+
+	// Updates rely on the unique constraint
+	resp = createWithSKU("SHIRT-02")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	jsonData, _ := json.Marshal(map[string]any{"sku": "SHIRT-01"})
+	resp, err := makeRequestWithToken(http.MethodPatch, fmt.Sprintf("/api/products/%d", created.Data.Id), bytes.NewReader(jsonData), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestOpenAPI(t *testing.T) {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/openapi.json", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc openapi.Document
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+
+	param := regexp.MustCompile(`:(\w+)`)
+	registered := map[string]bool{}
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == http.MethodHead {
+			continue
+		}
+		path := param.ReplaceAllString(route.Path, "{$1}")
+		if len(path) > 1 {
+			path = strings.TrimRight(path, "/")
+		}
+		registered[route.Method+" "+path] = true
+
+		op := doc.Paths[path][strings.ToLower(route.Method)]
+		if assert.NotNil(t, op, "%s %s is missing from the spec", route.Method, path) {
+			assert.NotEmpty(t, op.Summary, "%s %s is not documented", route.Method, path)
+		}
+	}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			assert.True(t, registered[strings.ToUpper(method)+" "+path], "%s %s is documented but not registered", method, path)
+		}
+	}
+
+	register := doc.Paths["/api/user/register"]["post"]
+	if assert.NotNil(t, register) && assert.NotNil(t, register.RequestBody) {
+		ref := register.RequestBody.Content[fiber.MIMEApplicationJSON].Schema.Ref
+		assert.Equal(t, "#/components/schemas/RegisterUserInput", ref)
+	}
+
+	input := doc.Components.Schemas["RegisterUserInput"]
+	if assert.NotNil(t, input) {
+		assert.ElementsMatch(t, []string{"username", "email", "password", "firstName"}, input.Required)
+		assert.Equal(t, 8, *input.Properties["password"].MinLength)
+		assert.Equal(t, "email", input.Properties["email"].Format)
+		assert.NotContains(t, input.Properties, "role")
+		assert.Equal(t, utils.UsernamePattern.String(), input.Properties["username"].Pattern)
+	}
+
+	product := doc.Components.Schemas["CreateProductInput"]
+	if assert.NotNil(t, product) {
+		assert.Equal(t, 1.0, *product.Properties["price"].Minimum)
+		assert.Equal(t, utils.SKUPattern.String(), product.Properties["sku"].Pattern)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/docs", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+}
+
+// Test the security documented for every route matches its middleware:
+// credentials, API keys and the permission the route requires
+func TestOpenAPISecurity(t *testing.T) {
+	const (
+		sessionOnly = "API keys cannot be used for this request."
+		forbidden   = "Forbidden access this resource."
+	)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/openapi.json", nil), -1)
+	require.NoError(t, err)
+	var doc openapi.Document
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+
+	// Every probe logs in anew, since one of them logs out
+	probeUser := func(username string, role models.Role) func() string {
+		assert.Equal(t, http.StatusOK, registerUser(t, app, username, username+"@example.com").StatusCode)
+		if role != models.User {
+			user, err := repos.Users.FindByUsername(context.Background(), username)
+			require.NoError(t, err)
+			require.NoError(t, repos.Users.UpdateRole(context.Background(), user.Id, role))
+		}
+		return func() string {
+			return responseCookie(loginAs(t, app, username, "password12345678"), utils.AccessTokenCookie)
+		}
+	}
+	session := probeUser("prober", models.User)
+	key := createAPIKey(t, session(), handler.CreateAPIKeyInput{Name: "prober"}).Key
+
+	probe := func(method, path string, authenticate func(*http.Request)) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Content-Type", "application/json")
+		if authenticate != nil {
+			authenticate(req)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+
+		var body apperr.Response
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Message
+	}
+	withCookie := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: utils.AccessTokenCookie, Value: token})
+		}
+	}
+
+	param := regexp.MustCompile(`\{\w+\}`)
+	permission := regexp.MustCompile(`Requires the (\S+) permission\.`)
+	holders := map[string]func() string{}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			method, url := strings.ToUpper(method), param.ReplaceAllString(path, "0")
+			route := method + " " + path
+
+			status, _ := probe(method, url, nil)
+			if len(op.Security) == 0 {
+				assert.NotEqual(t, http.StatusUnauthorized, status, "%s is documented as public", route)
+				continue
+			}
+			assert.Equal(t, http.StatusUnauthorized, status, "%s is documented as authenticated", route)
+
+			_, message := probe(method, url, func(req *http.Request) { req.Header.Set(utils.APIKeyHeader, key) })
+			assert.Equal(t, len(op.Security) == 1, message == sessionOnly, "%s documents API keys wrong", route)
+
+			_, message = probe(method, url, withCookie(session()))
+			required := permission.FindStringSubmatch(op.Description)
+			assert.Equal(t, required != nil, message == forbidden, "%s documents its permission wrong", route)
+			if required == nil {
+				continue
+			}
+
+			// Holding just the documented permission is enough
+			if holders[required[1]] == nil {
+				role := models.Roles{Name: "holds " + required[1]}
+				require.NoError(t, repos.Roles.Create(context.Background(), &role, []string{required[1]}))
+				holders[required[1]] = probeUser("holder"+strconv.Itoa(len(holders)), models.Role(role.Name))
+			}
+			_, message = probe(method, url, withCookie(holders[required[1]]()))
+			assert.NotEqual(t, forbidden, message, "%s requires more than %s", route, required[1])
+		}
+	}
+}
+
+func postJSON(t *testing.T, url string, body any) *http.Response {
+	jsonData, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestPasswordReset(t *testing.T) {
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "forgetful", "forgetful@example.com").StatusCode)
+
+	resp := loginAs(t, app, "forgetful", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refreshToken := responseCookie(resp, utils.RefreshTokenCookie)
+	key := createAPIKey(t, responseCookie(resp, utils.AccessTokenCookie), handler.CreateAPIKeyInput{Name: "script"})
+
+	var known, unknown struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+
+	resp = postJSON(t, "/api/user/password/forgot", map[string]string{"email": "forgetful@example.com"})
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&known))
+
+	msg, ok := sentMail.next("forgetful@example.com", "Reset", 5*time.Second)
+	if !assert.True(t, ok, "no reset email was sent") {
+		return
+	}
+	assert.Equal(t, "forgetful@example.com", msg.To)
+	match := regexp.MustCompile(`[?&]token=([\w-]+)`).FindStringSubmatch(msg.Body)
+	if !assert.Len(t, match, 2, "the email has no reset link") {
+		return
+	}
+	token := match[1]
+
+	// Unknown addresses get the same answer and no email.
+	resp = postJSON(t, "/api/user/password/forgot", map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&unknown))
+	assert.Equal(t, known, unknown)
+	_, ok = sentMail.next("nobody@example.com", "", 200*time.Millisecond)
+	assert.False(t, ok, "an email was sent to an unknown address")
+
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{"token": token, "password": "weak"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "the new password is validated")
+
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{"token": token, "password": "N3w-Passw0rd!"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{"token": token, "password": "An0ther-Pass!"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "tokens are single-use")
+
+	resp, err := refreshWith(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "sessions are revoked on reset")
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/user/products", nil, key.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "so are API keys")
+
+	assert.Equal(t, http.StatusUnauthorized, loginAs(t, app, "forgetful", "password12345678").StatusCode)
+	assert.Equal(t, http.StatusOK, loginAs(t, app, "forgetful", "N3w-Passw0rd!").StatusCode)
+
+	// A newer email replaces the link of an older one.
+	postJSON(t, "/api/user/password/forgot", map[string]string{"email": "forgetful@example.com"})
+	first, _ := sentMail.next("forgetful@example.com", "Reset", 5*time.Second)
+	postJSON(t, "/api/user/password/forgot", map[string]string{"email": "forgetful@example.com"})
+	second, _ := sentMail.next("forgetful@example.com", "Reset", 5*time.Second)
+	link := regexp.MustCompile(`token=([\w-]+)`)
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{
+		"token": link.FindStringSubmatch(first.Body)[1], "password": "Th1rd-Passw0rd",
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{
+		"token": link.FindStringSubmatch(second.Body)[1], "password": "Th1rd-Passw0rd",
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Expired tokens are refused.
+	user, err := repos.Users.FindByUsername(context.Background(), "forgetful")
+	assert.NoError(t, err)
+	assert.NoError(t, repos.PasswordResets.Create(context.Background(), &models.PasswordResetTokens{
+		UserID:    user.Id,
+		TokenHash: utils.HashToken("expired-token"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	resp = postJSON(t, "/api/user/password/reset", map[string]string{"token": "expired-token", "password": "F0urth-Passw0rd"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMailers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.mbox")
+	mailer, err := mail.New(config.Mail{Driver: config.MailFile, From: "no-reply@example.com", File: path})
+	assert.NoError(t, err)
+
+	assert.NoError(t, mailer.Send(context.Background(), mail.Message{To: "a@example.com", Subject: "First", Body: "one"}))
+	assert.NoError(t, mailer.Send(context.Background(), mail.Message{To: "b@example.com", Subject: "Second", Body: "two"}))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "From no-reply@example.com "))
+	assert.Contains(t, string(content), "To: b@example.com\nSubject: Second\n")
+
+	_, err = mail.New(config.Mail{Driver: config.MailSMTP, From: "no-reply@example.com"})
+	assert.Error(t, err, "the smtp driver needs a host")
+
+	mailer, err = mail.New(config.Default().Mail)
+	assert.NoError(t, err)
+	assert.IsType(t, mail.LogMailer{}, mailer)
+
+	// The certificate of the SMTP server is verified after STARTTLS
+	mailer, err = mail.New(cfg.Mail)
+	assert.NoError(t, err)
+	err = mailer.Send(context.Background(), mail.Message{To: "c@example.com", Subject: "Untrusted", Body: "three"})
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknownAuthority)
+
+	// The outbox outlives the request and holds the shutdown until it is done
+	outbox := mail.NewOutbox(time.Second)
+	requestCtx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	outbox.Go(requestCtx, func(ctx context.Context) {
+		<-release
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+		assert.NoError(t, ctx.Err())
+	})
+	cancel()
+
+	waitCtx, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	assert.ErrorIs(t, outbox.Wait(waitCtx), context.DeadlineExceeded)
+	close(release)
+	assert.NoError(t, outbox.Wait(context.Background()))
+}
+
+// Test new users have to verify their email before creating products
+func TestEmailVerification(t *testing.T) {
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "unverified", "unverified@example.com").StatusCode)
+	msg, ok := sentMail.next("unverified@example.com", "Verify", 5*time.Second)
+	assert.True(t, ok, "registration sends a verification email")
+
+	resp := loginAs(t, app, "unverified", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	product, _ := json.Marshal(map[string]interface{}{"name": "Spam", "quantity": 1, "price": 1})
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/products", bytes.NewReader(product), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(msg.Body))
+	assert.NoError(t, err)
+	signed := link.Query().Get("token")
+
+	user, err := repos.Users.FindByUsername(context.Background(), "unverified")
+	assert.NoError(t, err)
+	payload := fmt.Sprintf("%d:%s", user.Id, user.Email)
+	secret := []byte(cfg.JWT.Secret)
+
+	for name, bad := range map[string]string{
+		"tampered":      signed[:len(signed)-2] + "xx",
+		"expired":       utils.SignToken(secret, "email-verification", payload, time.Now().Add(-time.Minute)),
+		"other purpose": utils.SignToken(secret, "password-reset", payload, time.Now().Add(time.Hour)),
+		"other email":   utils.SignToken(secret, "email-verification", fmt.Sprintf("%d:someone@example.com", user.Id), time.Now().Add(time.Hour)),
+	} {
+		resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/user/verify-email?token="+url.QueryEscape(bad), nil), -1)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, name)
+	}
+
+	// A resent link works as well
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/verify-email/resend", nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	verifyEmail(t, "unverified@example.com")
+
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/products", bytes.NewReader(product), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/verify-email/resend", nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+// totpNow returns the current time, after waiting for the next TOTP period
+// when the current one is about to end, so codes computed from it are still
+// current when the server checks them.
+func totpNow() time.Time {
+	now := time.Now()
+	if left := utils.TOTPPeriod - now.Sub(time.Unix(utils.TOTPStep(now)*int64(utils.TOTPPeriod.Seconds()), 0)); left < 2*time.Second {
+		time.Sleep(left)
+		now = time.Now()
+	}
+	return now
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	code, err := utils.TOTPCode(secret, at)
+	assert.NoError(t, err)
+	return code
+}
+
+// enableMFA enrolls an authenticator for the user of token and confirms a
+// code of the current period. It returns the TOTP secret together with the
+// new access token and the recovery codes.
+func enableMFA(t *testing.T, token string) (string, handler.MFAConfirmation) {
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/user/mfa/enroll", nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var enrollment struct{ Data handler.MFAEnrollment }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.Data.URI, "otpauth://totp/"), enrollment.Data.URI)
+	assert.Contains(t, enrollment.Data.URI, "secret="+enrollment.Data.Secret)
+
+	body, _ := json.Marshal(handler.MFACodeInput{Code: totpCode(t, enrollment.Data.Secret, totpNow())})
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/mfa/confirm", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var confirmation struct{ Data handler.MFAConfirmation }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&confirmation))
+	assert.Len(t, confirmation.Data.RecoveryCodes, 10)
+	assert.NotEmpty(t, confirmation.Data.Token)
+
+	return enrollment.Data.Secret, confirmation.Data
+}
+
+// mfaChallenge logs in with a password and returns the MFA token of the
+// answer.
+func mfaChallenge(t *testing.T, username string) string {
+	resp := loginAs(t, app, username, "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, responseCookie(resp, utils.AccessTokenCookie), "no session before the second factor")
+
+	var challenge struct{ Data handler.MFAChallenge }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+	assert.NotEmpty(t, challenge.Data.Token)
+	return challenge.Data.Token
+}
+
+// Test enrolling an authenticator and logging in with TOTP and recovery codes
+func TestMFA(t *testing.T) {
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "twofactor", "twofactor@example.com").StatusCode)
+	resp := loginAs(t, app, "twofactor", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	oldToken := responseCookie(resp, utils.AccessTokenCookie)
+
+	// Enrollment alone changes nothing, a code has to be confirmed
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/user/mfa/enroll", nil, oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/mfa/confirm", bytes.NewReader([]byte(`{"code":"000000"}`)), oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, loginAs(t, app, "twofactor", "password12345678").StatusCode)
+
+	secret, confirmation := enableMFA(t, oldToken)
+	codes := confirmation.RecoveryCodes
+
+	// Sessions opened before are revoked
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, confirmation.Token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/mfa/enroll", nil, confirmation.Token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// A wrong code counts as a failed login
+	challenge := mfaChallenge(t, "twofactor")
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: challenge, Code: "123456"})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	user, err := repos.Users.FindByUsername(context.Background(), "twofactor")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.FailedLogins)
+
+	// The code of the next period is still accepted, but only once
+	code := totpCode(t, secret, totpNow().Add(utils.TOTPPeriod))
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: challenge, Code: code})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, responseCookie(resp, utils.AccessTokenCookie))
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: mfaChallenge(t, "twofactor"), Code: code})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	user, err = repos.Users.FindByUsername(context.Background(), "twofactor")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.FailedLogins, "a correct password alone does not reset failures")
+
+	// Recovery codes work once, typed in any case
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: mfaChallenge(t, "twofactor"), Code: strings.ToUpper(codes[0])})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: mfaChallenge(t, "twofactor"), Code: codes[0]})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	user, err = repos.Users.FindByUsername(context.Background(), "twofactor")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.FailedLogins, "only the spent code counts since the last login")
+
+	expired := utils.SignToken([]byte(cfg.JWT.Secret), "mfa-challenge", fmt.Sprint(user.Id), time.Now().Add(-time.Second))
+	resp = postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{Token: expired, Code: codes[1]})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// New recovery codes replace the old ones
+	body, _ := json.Marshal(handler.MFACodeInput{Code: codes[1]})
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/mfa/recovery-codes", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var regenerated struct{ Data []string }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&regenerated))
+	assert.Len(t, regenerated.Data, 10)
+
+	body, _ = json.Marshal(handler.MFACodeInput{Code: codes[2]})
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/user/mfa", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body, _ = json.Marshal(handler.MFACodeInput{Code: regenerated.Data[0]})
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/user/mfa", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = loginAs(t, app, "twofactor", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, responseCookie(resp, utils.AccessTokenCookie), "a password is enough again")
+}
+
+// Test two-factor authentication is mandatory for admins
+func TestAdminMFA(t *testing.T) {
+	challenge := mfaChallenge(t, "adminuser")
+	resp := postJSON(t, "/api/user/login/mfa", handler.MFALoginInput{
+		Token: challenge,
+		Code:  totpCode(t, adminTOTPSecret, totpNow().Add(utils.TOTPPeriod)),
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	refreshToken := responseCookie(resp, utils.RefreshTokenCookie)
+
+	// Refreshed sessions keep counting as opened with a second factor
+	resp, err := refreshWith(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, _ := json.Marshal(handler.MFACodeInput{Code: "000000"})
+	resp, err = makeRequestWithToken(http.MethodDelete, "/api/user/mfa", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// A new admin can use the API, but not the admin routes until enrolled
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "newadmin", "newadmin@example.com").StatusCode)
+	user, err := repos.Users.FindByUsername(context.Background(), "newadmin")
+	assert.NoError(t, err)
+	assert.NoError(t, repos.Users.UpdateRole(context.Background(), user.Id, models.Admin))
+
+	resp = loginAs(t, app, "newadmin", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	newAdminToken := responseCookie(resp, utils.AccessTokenCookie)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, newAdminToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, newAdminToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Nor any other use of the admin permissions
+	productURL := "/api/products/" + createProductWithToken(t, token)
+	update, _ := json.Marshal(map[string]interface{}{"price": 42})
+	resp, err = makeRequestWithToken(http.MethodPatch, productURL, bytes.NewReader(update), newAdminToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/products", nil, newAdminToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, confirmation := enableMFA(t, newAdminToken)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/admin/all-user", nil, confirmation.Token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithToken(http.MethodPatch, productURL, bytes.NewReader(update), confirmation.Token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	adminKey := createAPIKey(t, confirmation.Token, handler.CreateAPIKeyInput{Name: "reports", Scopes: []string{models.PermUsersRead}})
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/admin/all-user", nil, adminKey.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// An admin resets the second factor of one who lost it, which revokes
+	// the keys made with it
+	resp, err = makeRequestWithToken(http.MethodDelete, fmt.Sprintf("/api/admin/user/%d/mfa", user.Id), nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/admin/all-user", nil, adminKey.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	user, err = repos.Users.FindByID(context.Background(), user.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, user.MFAEnabledAt)
+	assert.Nil(t, user.TOTPSecret)
+}
+
+// createAPIKey creates an API key for the user of token and returns it.
+func createAPIKey(t *testing.T, token string, input handler.CreateAPIKeyInput) handler.NewAPIKey {
+	body, _ := json.Marshal(input)
+	resp, err := makeRequestWithToken(http.MethodPost, "/api/user/api-keys", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created struct{ Data handler.NewAPIKey }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	return created.Data
+}
+
+// Helper function to make requests authenticated with an API key
+func makeRequestWithAPIKey(method, url string, body io.Reader, key string, bearer bool) (*http.Response, error) {
+	req := httptest.NewRequest(method, url, body)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer {
+		req.Header.Set("Authorization", "Bearer "+key)
+	} else {
+		req.Header.Set(utils.APIKeyHeader, key)
+	}
+
+	return app.Test(req, -1)
+}
+
+// Test API keys authenticate within their scopes until revoked
+func TestAPIKeys(t *testing.T) {
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "scripter", "scripter@example.com").StatusCode)
+	resp := loginAs(t, app, "scripter", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	created := createAPIKey(t, token, handler.CreateAPIKeyInput{Name: "ci"})
+	assert.True(t, strings.HasPrefix(created.Key, utils.APIKeyPrefix), created.Key)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix), created.Prefix)
+	assert.Less(t, len(created.Prefix), len(created.Key))
+
+	// Both headers are accepted
+	for _, bearer := range []bool{false, true} {
+		resp, err := makeRequestWithAPIKey(http.MethodGet, "/api/user/products", nil, created.Key, bearer)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	key, err := repos.APIKeys.FindByID(context.Background(), created.Id)
+	assert.NoError(t, err)
+	assert.NotNil(t, key.LastUsedAt)
+	assert.NotEqual(t, created.Key, key.KeyHash)
+
+	// Keys cannot manage sessions or other keys
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/user/api-keys", nil, created.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodPost, "/api/user/logout", nil, created.Key, true)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Scopes are limited to what the role grants
+	body, _ := json.Marshal(handler.CreateAPIKeyInput{Name: "reader", Scopes: []string{models.PermUsersRead}})
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/api-keys", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	body, _ = json.Marshal(handler.CreateAPIKeyInput{Name: "typo", Scopes: []string{"users:reed"}})
+	resp, err = makeRequestWithToken(http.MethodPost, "/api/user/api-keys", bytes.NewReader(body), token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// An admin key only carries its scopes
+	adminKey := createAPIKey(t, adminAuthToken, handler.CreateAPIKeyInput{Name: "reports", Scopes: []string{models.PermUsersRead}})
+	assert.Equal(t, []string{models.PermUsersRead}, adminKey.ScopeList())
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/admin/all-user", nil, adminKey.Key, true)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/admin/roles", nil, adminKey.Key, true)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Keys belong to their owner
+	resp, err = makeRequestWithToken(http.MethodDelete, fmt.Sprintf("/api/user/api-keys/%d", adminKey.Id), nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	body, _ = json.Marshal(map[string]any{"name": "nightly", "scopes": []string{models.PermRolesRead}})
+	resp, err = makeRequestWithToken(http.MethodPatch, fmt.Sprintf("/api/user/api-keys/%d", adminKey.Id), bytes.NewReader(body), adminAuthToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var updated struct{ Data models.APIKeys }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+	assert.Equal(t, "nightly", updated.Data.Name)
+	assert.Equal(t, []string{models.PermRolesRead}, updated.Data.ScopeList())
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/admin/roles", nil, adminKey.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Revoked keys stay listed but stop working
+	resp, err = makeRequestWithToken(http.MethodDelete, fmt.Sprintf("/api/user/api-keys/%d", created.Id), nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/user/products", nil, created.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodGet, "/api/user/products", nil, utils.APIKeyPrefix+"unknown", true)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/api-keys", nil, token)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed struct{ Data []models.APIKeys }
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	assert.Len(t, listed.Data, 1)
+	assert.NotNil(t, listed.Data[0].RevokedAt)
+	assert.Empty(t, listed.Data[0].KeyHash)
+}
+
+// Test API keys need the products:write scope to change their owner's
+// products, which sessions do not
+func TestAPIKeyProductScopes(t *testing.T) {
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "keyseller", "keyseller@example.com").StatusCode)
+	verifyEmail(t, "keyseller@example.com")
+	resp := loginAs(t, app, "keyseller", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	unscoped := createAPIKey(t, token, handler.CreateAPIKeyInput{Name: "reader"})
+	writer := createAPIKey(t, token, handler.CreateAPIKeyInput{Name: "writer", Scopes: []string{models.PermProductsWrite}})
+	assert.Equal(t, []string{models.PermProductsWrite}, writer.ScopeList())
+
+	productID := createNamedProduct(t, token, "Keyed Product", 10)
+	productURL := "/api/products/" + productID
+	jsonData, _ := json.Marshal(map[string]interface{}{"name": "Scripted", "description": "Made by a key", "price": 5, "quantity": 1})
+	update, _ := json.Marshal(map[string]interface{}{"price": 7})
+
+	resp, err := makeRequestWithAPIKey(http.MethodPost, "/api/products", bytes.NewReader(jsonData), unscoped.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodPatch, productURL, bytes.NewReader(update), unscoped.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodDelete, productURL, nil, unscoped.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = makeRequestWithAPIKey(http.MethodPost, "/api/products", bytes.NewReader(jsonData), writer.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodPatch, productURL, bytes.NewReader(update), writer.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequestWithAPIKey(http.MethodDelete, productURL, nil, writer.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The scope only covers the owner's products
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "keybuyer", "keybuyer@example.com").StatusCode)
+	verifyEmail(t, "keybuyer@example.com")
+	resp = loginAs(t, app, "keybuyer", "password12345678")
+	otherURL := "/api/products/" + createProductWithToken(t, responseCookie(resp, utils.AccessTokenCookie))
+	resp, err = makeRequestWithAPIKey(http.MethodDelete, otherURL, nil, writer.Key, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// legacyToken returns an access token for username as it was signed before
+// the keyset, with HS256 and the shared secret.
+func legacyToken(t *testing.T, username string, secret []byte, header map[string]any) string {
+	user, err := repos.Users.FindByUsername(context.Background(), username)
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       user.Id,
+		"username": user.Username,
+		"role":     string(models.User),
+		"jti":      uuid.NewString(),
+		"exp":      time.Now().Add(time.Minute).Unix(),
+	})
+	for name, value := range header {
+		token.Header[name] = value
+	}
+
+	signed, err := token.SignedString(secret)
+	assert.NoError(t, err)
+	return signed
+}
+
+// Test access tokens are signed with a published key and verified without
+// the shared secret
+func TestJWKS(t *testing.T) {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "max-age=")
+
+	var set keyset.JWKS
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	if !assert.NotEmpty(t, set.Keys) {
+		return
+	}
+	assert.Equal(t, "OKP", set.Keys[0].Kty)
+	assert.Equal(t, "EdDSA", set.Keys[0].Alg)
+	assert.Equal(t, "sig", set.Keys[0].Use)
+
+	assert.Equal(t, http.StatusOK, registerUser(t, app, "verifier", "verifier@example.com").StatusCode)
+	resp = loginAs(t, app, "verifier", "password12345678")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	token := responseCookie(resp, utils.AccessTokenCookie)
+
+	// Other services only need the published keys
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
+		for _, jwk := range set.Keys {
+			if jwk.Kid == token.Header["kid"] {
+				x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+				return ed25519.PublicKey(x), err
+			}
+		}
+		return nil, errors.New("unknown kid")
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	assert.NoError(t, err)
+	assert.True(t, parsed.Valid)
+
+	// HS256 tokens are refused unless accepted during the migration
+	legacy := legacyToken(t, "verifier", []byte(cfg.JWT.Secret), nil)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, legacy)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"id": 1, "jti": "none"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	resp, err = makeRequestWithToken(http.MethodGet, "/api/user/products", nil, unsigned)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	migrating := cfg
+	migrating.JWT.AcceptHS256 = true
+	migratingKeys := keyset.New(migrating.JWT, repos.SigningKeys)
+	assert.NoError(t, migratingKeys.Rotate(context.Background()))
+	migratingApp := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	routes.SetupRoutes(migratingApp, handler.Dependencies{
+		Config:    migrating,
+		Repos:     repos,
+		Lifecycle: lifecycle.New(time.Second),
+		Metrics:   metrics.New(),
+		Keys:      migratingKeys,
+	})
+	withCookie := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/products", nil)
+		req.AddCookie(&http.Cookie{Name: utils.AccessTokenCookie, Value: token})
+		resp, err := migratingApp.Test(req, -1)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, withCookie(legacy))
+	assert.Equal(t, http.StatusOK, withCookie(token), "tokens of the keyset still work")
+
+	// A public key is never taken for an HS256 secret
+	x, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	forged := legacyToken(t, "verifier", x, map[string]any{"kid": set.Keys[0].Kid})
+	assert.Equal(t, http.StatusUnauthorized, withCookie(forged))
+}
+
+// Test signing keys are published before they sign and stay valid for the
+// overlap after they stopped
+func TestKeyRotation(t *testing.T) {
+	rotating := keyset.New(config.JWT{
+		Secret:           "rotation-secret",
+		Algorithm:        config.JWTAlgorithmEdDSA,
+		RotationInterval: config.Duration(2 * time.Second),
+		RotationOverlap:  config.Duration(time.Second),
+	}, repository.NewMemory().SigningKeys)
+	ctx := context.Background()
+
+	kidOf := func(token string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		assert.NoError(t, err)
+		return parsed.Header["kid"].(string)
+	}
+	kids := func() []string {
+		var kids []string
+		for _, jwk := range rotating.JWKS().Keys {
+			kids = append(kids, jwk.Kid)
+		}
+		return kids
+	}
+	verify := func(token string) error {
+		_, err := jwt.Parse(token, rotating.Keyfunc)
+		return err
+	}
+
+	assert.NoError(t, rotating.Rotate(ctx))
+	first, err := rotating.Sign(jwt.MapClaims{"id": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{kidOf(first)}, kids())
+
+	// The next key is published an overlap before it signs
+	time.Sleep(1200 * time.Millisecond)
+	assert.NoError(t, rotating.Rotate(ctx))
+	assert.Len(t, kids(), 2)
+	token, err := rotating.Sign(jwt.MapClaims{"id": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, kidOf(first), kidOf(token))
+
+	// Then signs, while tokens of the old key stay valid
+	time.Sleep(1200 * time.Millisecond)
+	assert.NoError(t, rotating.Rotate(ctx))
+	token, err = rotating.Sign(jwt.MapClaims{"id": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, kids()[1], kidOf(token))
+	assert.NoError(t, verify(first))
+
+	// Until the overlap is over
+	time.Sleep(1200 * time.Millisecond)
+	assert.NoError(t, rotating.Rotate(ctx))
+	assert.NotContains(t, kids(), kidOf(first))
+	assert.Error(t, verify(first))
+	assert.NoError(t, verify(token))
+
+	// RS256 keys are published with their modulus and exponent
+	rsaKeys := keyset.New(config.JWT{
+		Secret:           "rotation-secret",
+		Algorithm:        config.JWTAlgorithmRS256,
+		RotationInterval: config.Duration(time.Hour),
+		RotationOverlap:  config.Duration(time.Minute),
+	}, repository.NewMemory().SigningKeys)
+	assert.NoError(t, rsaKeys.Rotate(ctx))
+	jwk := rsaKeys.JWKS().Keys[0]
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, "RS256", jwk.Alg)
+	assert.NotEmpty(t, jwk.N)
+	assert.Equal(t, "AQAB", jwk.E)
+
+	token, err = rsaKeys.Sign(jwt.MapClaims{"id": 1})
+	assert.NoError(t, err)
+	_, err = jwt.Parse(token, rsaKeys.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+	assert.NoError(t, err)
+	assert.Error(t, verify(token), "keys of another keyset are unknown")
+}
@@ -1,12 +1,219 @@
 package tests
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"go-task/config"
+	"go-task/mail"
+	"io"
 	"log"
+	"math/big"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// mailbox is a local SMTP server standing in for a real one. It offers
+// STARTTLS with a certificate of its own and keeps the messages it
+// receives so tests can pick them up by recipient.
+type mailbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+	arrived  chan struct{}
+	tls      *tls.Config
+}
+
+// startMailbox listens on a random local port and returns the mailbox
+// together with the settings of a mailer delivering to it and the roots
+// that trust its certificate.
+func startMailbox() (*mailbox, config.Mail, *x509.CertPool) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Failed to start SMTP stand-in: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+
+	cert, roots := selfSignedCertificate(addr.IP)
+	m := &mailbox{
+		arrived: make(chan struct{}),
+		tls:     &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+
+	return m, config.Mail{
+		Driver: config.MailSMTP,
+		From:   "no-reply@example.com",
+		SMTP:   config.SMTP{Host: addr.IP.String(), Port: addr.Port},
+	}, roots
+}
+
+// selfSignedCertificate returns a certificate for ip and a pool trusting it.
+func selfSignedCertificate(ip net.IP) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalf("Failed to generate SMTP stand-in key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{ip},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatalf("Failed to create SMTP stand-in certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.Fatalf("Failed to parse SMTP stand-in certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+// serve speaks just enough SMTP for net/smtp to deliver a message.
+func (m *mailbox) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP stand-in")
+
+	secure := false
+	var to string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if secure {
+				text.PrintfLine("250 localhost")
+			} else {
+				text.PrintfLine("250-localhost")
+				text.PrintfLine("250 STARTTLS")
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, m.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, text, secure = tlsConn, textproto.NewConn(tlsConn), true
+		case "RCPT":
+			_, addr, _ := strings.Cut(arg, ":")
+			to = strings.Trim(addr, "<>")
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			raw, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.deliver(to, raw)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (m *mailbox) deliver(to string, raw []byte) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		log.Printf("SMTP stand-in received a malformed message: %v", err)
+		return
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	body, _ := io.ReadAll(msg.Body)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, mail.Message{
+		To:      to,
+		Subject: subject,
+		Body:    strings.ReplaceAll(string(body), "\r\n", "\n"),
+	})
+	close(m.arrived)
+	m.arrived = make(chan struct{})
+}
+
+// next removes and returns the oldest message to the recipient whose
+// subject contains subject, waiting up to timeout for it to arrive.
+func (m *mailbox) next(to, subject string, timeout time.Duration) (mail.Message, bool) {
+	deadline := time.After(timeout)
+	for {
+		m.mu.Lock()
+		for i, msg := range m.messages {
+			if msg.To == to && strings.Contains(msg.Subject, subject) {
+				m.messages = append(m.messages[:i], m.messages[i+1:]...)
+				m.mu.Unlock()
+				return msg, true
+			}
+		}
+		arrived := m.arrived
+		m.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-deadline:
+			return mail.Message{}, false
+		}
+	}
+}
+
 func CleanupDatabase(db *gorm.DB) {
+	if err := db.Exec("DELETE FROM revoked_tokens").Error; err != nil {
+		log.Fatalf("Failed to clean up revoked_tokens table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM refresh_tokens").Error; err != nil {
+		log.Fatalf("Failed to clean up refresh_tokens table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM password_reset_tokens").Error; err != nil {
+		log.Fatalf("Failed to clean up password_reset_tokens table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM recovery_codes").Error; err != nil {
+		log.Fatalf("Failed to clean up recovery_codes table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM api_key_scopes").Error; err != nil {
+		log.Fatalf("Failed to clean up api_key_scopes table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM api_keys").Error; err != nil {
+		log.Fatalf("Failed to clean up api_keys table: %v", err)
+	}
+
 	if err := db.Exec("DELETE FROM products").Error; err != nil {
 		log.Fatalf("Failed to clean up products table: %v", err)
 	}
@@ -14,4 +221,12 @@ func CleanupDatabase(db *gorm.DB) {
 	if err := db.Exec("DELETE FROM users").Error; err != nil {
 		log.Fatalf("Failed to clean up users table: %v", err)
 	}
+
+	if err := db.Exec("DELETE FROM role_permissions WHERE role_id IN (SELECT id FROM roles WHERE built_in = ?)", false).Error; err != nil {
+		log.Fatalf("Failed to clean up role_permissions table: %v", err)
+	}
+
+	if err := db.Exec("DELETE FROM roles WHERE built_in = ?", false).Error; err != nil {
+		log.Fatalf("Failed to clean up roles table: %v", err)
+	}
 }
@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"go-task/instrument"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestCarrier reads and writes the trace context headers of a request.
+type requestCarrier struct {
+	c *fiber.Ctx
+}
+
+func (r requestCarrier) Get(key string) string {
+	return r.c.Get(key)
+}
+
+func (r requestCarrier) Set(key, value string) {
+	r.c.Request().Header.Set(key, value)
+}
+
+func (r requestCarrier) Keys() []string {
+	keys := make([]string, 0)
+	for key := range r.c.GetReqHeaders() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// responseCarrier writes the trace context headers of a response.
+type responseCarrier struct {
+	c *fiber.Ctx
+}
+
+func (r responseCarrier) Get(key string) string {
+	return string(r.c.Response().Header.Peek(key))
+}
+
+func (r responseCarrier) Set(key, value string) {
+	r.c.Set(key, value)
+}
+
+func (r responseCarrier) Keys() []string {
+	keys := make([]string, 0)
+	for key := range r.c.GetRespHeaders() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Middleware starts a server span for every request, continuing the trace
+// of an incoming traceparent header, and returns the trace context in the
+// traceparent response header. The span is named after the route template,
+// such as "GET /api/products/:id".
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(c.UserContext(), requestCarrier{c})
+
+		method := utils.CopyString(c.Method())
+		ctx, span := Tracer().Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(utils.CopyString(c.Path())),
+				semconv.ClientAddress(c.IP()),
+				semconv.UserAgentOriginal(utils.CopyString(c.Get(fiber.HeaderUserAgent))),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		propagator.Inject(ctx, responseCarrier{c})
+
+		self := c.Route()
+		err := c.Next()
+
+		status := instrument.Status(c, err)
+		if err != nil && status >= fiber.StatusInternalServerError {
+			span.RecordError(err)
+		}
+
+		if route, ok := instrument.Route(c, self); ok {
+			span.SetName(method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, "")
+		}
+
+		return err
+	}
+}
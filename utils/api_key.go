@@ -0,0 +1,25 @@
+package utils
+
+const (
+	// APIKeyHeader carries an API key, as an alternative to the
+	// Authorization header with the Bearer scheme.
+	APIKeyHeader = "X-API-Key"
+	// APIKeyPrefix starts every API key. It tells keys apart from access
+	// tokens and makes leaked keys easy to scan for.
+	APIKeyPrefix = "gtk_"
+
+	// apiKeyVisibleLength is how much of a key is stored in the clear.
+	apiKeyVisibleLength = len(APIKeyPrefix) + 8
+)
+
+// GenerateAPIKey returns a new API key and its prefix, the part that may be
+// stored and shown to identify the key.
+func GenerateAPIKey() (string, string, error) {
+	token, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := APIKeyPrefix + token
+	return key, key[:apiKeyVisibleLength], nil
+}
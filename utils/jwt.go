@@ -2,43 +2,68 @@ package utils
 
 import (
 	"fmt"
-	"go-task/config"
 	"go-task/models"
-	"log"
+	"log/slog"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JwtCredentialStruct struct {
 	Id       uint
 	Username string
 	Role     models.Role
+	Jti      string
+	// MFA is set when the session was opened with a second factor.
+	MFA bool
 }
 
-var JwtExpire int64
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
 
-func CreateJWT(identity JwtCredentialStruct) string {
-	token := jwt.New(jwt.SigningMethodHS256)
+	AccessTokenCookie  = "_token"
+	RefreshTokenCookie = "_refresh_token"
+)
 
-	JwtExpire = time.Now().Add(time.Hour * 24).Unix()
+var JwtExpire int64
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["id"] = identity.Id
-	claims["username"] = identity.Username
-	claims["admin"] = identity.Role == models.Admin
-	claims["exp"] = JwtExpire
+// TokenSigner signs the claims of access tokens, see keyset.Keyset.
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+func identityClaims(identity JwtCredentialStruct) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"id":       identity.Id,
+		"username": identity.Username,
+		"role":     string(identity.Role),
+		"iat":      time.Now().Unix(),
+	}
+	if identity.MFA {
+		claims["mfa"] = true
+	}
+	return claims
+}
 
-	secretKey := config.GetEnv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "secret"
+// CreateJWT signs an access token for identity with signer.
+func CreateJWT(identity JwtCredentialStruct, signer TokenSigner) string {
+	if identity.Jti == "" {
+		identity.Jti = uuid.NewString()
 	}
 
-	t, err := token.SignedString([]byte(secretKey))
+	JwtExpire = time.Now().Add(AccessTokenTTL).Unix()
+
+	claims := identityClaims(identity)
+	claims["exp"] = JwtExpire
+	claims["jti"] = identity.Jti
+
+	t, err := signer.Sign(claims)
 	if err != nil {
-		log.Printf("Error signing JWT token: %v", err)
+		slog.Error("Failed to sign JWT", "error", err)
 		return ""
 	}
 
@@ -58,10 +83,61 @@ func GetUserIDFromToken(c *fiber.Ctx) (uint, error) {
 	return uint(userId), nil
 }
 
-func IsAdmin(c *fiber.Ctx) bool {
+func GetRoleFromToken(c *fiber.Ctx) models.Role {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	role, _ := claims["role"].(string)
+	return models.Role(role)
+}
+
+func GetTokenIDFromToken(c *fiber.Ctx) string {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	jti, _ := claims["jti"].(string)
+	return jti
+}
+
+func GetTokenExpiry(c *fiber.Ctx) time.Time {
+	user := c.Locals("user").(*jwt.Token)
+
+	exp, err := user.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Now().Add(AccessTokenTTL)
+	}
+
+	return exp.Time
+}
+
+// GetMFAFromToken reports whether the session of the current token was
+// opened with a second factor.
+func GetMFAFromToken(c *fiber.Ctx) bool {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	mfa, _ := claims["mfa"].(bool)
+	return mfa
+}
+
+// APIKeyToken returns an unsigned token with the claims of identity for a
+// request authenticated with the API key keyID, so handlers read the user
+// of key and token requests the same way.
+func APIKeyToken(identity JwtCredentialStruct, keyID uint) *jwt.Token {
+	claims := identityClaims(identity)
+	claims["api_key"] = keyID
+
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	token.Valid = true
+	return token
+}
+
+// GetAPIKeyIDFromToken returns the ID of the API key the request was
+// authenticated with, or 0 for access tokens.
+func GetAPIKeyIDFromToken(c *fiber.Ctx) uint {
 	user := c.Locals("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
 
-	isAdmin := claims["admin"] == true
-	return isAdmin
+	id, _ := claims["api_key"].(uint)
+	return id
 }
@@ -0,0 +1,552 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-task/apperr"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+const (
+	OpEquals   = "="
+	OpGte      = ">="
+	OpLte      = "<="
+	OpAfter    = ">"
+	OpContains = "contains"
+)
+
+type (
+	// Condition is a single declarative filter so the same query can run
+	// against the database or against an in-memory slice.
+	Condition struct {
+		Column string
+		Op     string
+		Value  any
+	}
+
+	// Filter parses the raw value of a query parameter into a Condition.
+	Filter func(value string) (Condition, error)
+
+	// ListSpec describes which sort keys and filters a list endpoint accepts.
+	// Sortable maps the public sort key to its column.
+	ListSpec struct {
+		Sortable    map[string]string
+		DefaultSort string
+		Filters     map[string]Filter
+	}
+
+	SortField struct {
+		Column string
+		Desc   bool
+	}
+
+	ListQuery struct {
+		Limit      int
+		Offset     int
+		Cursor     string
+		Sort       []SortField
+		Conditions []Condition
+
+		sortKey string
+	}
+
+	ListMeta struct {
+		Total      int64  `json:"total"`
+		Limit      int    `json:"limit"`
+		Offset     int    `json:"offset"`
+		NextCursor string `json:"nextCursor,omitempty"`
+	}
+
+	listCursor struct {
+		Sort   string            `json:"s"`
+		Values []json.RawMessage `json:"v"`
+	}
+)
+
+func badQuery(format string, args ...any) error {
+	return apperr.BadRequest(format, args...)
+}
+
+// ParseLimit reads the limit query parameter, DefaultPageLimit when it is
+// missing.
+func ParseLimit(c *fiber.Ctx) (int, error) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return DefaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > MaxPageLimit {
+		return 0, badQuery("limit must be between 1 and %d", MaxPageLimit)
+	}
+	return limit, nil
+}
+
+// ParseListQuery reads limit, offset, cursor, sort and the filters allowed by
+// spec from the query string. Invalid input is reported as a bad request.
+func ParseListQuery(c *fiber.Ctx, spec ListSpec) (ListQuery, error) {
+	query := ListQuery{Limit: DefaultPageLimit}
+
+	limit, err := ParseLimit(c)
+	if err != nil {
+		return query, err
+	}
+	query.Limit = limit
+
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return query, badQuery("offset must be a positive number")
+		}
+		query.Offset = offset
+	}
+
+	query.Cursor = c.Query("cursor")
+	if query.Cursor != "" && query.Offset > 0 {
+		return query, badQuery("cursor and offset cannot be combined")
+	}
+
+	query.sortKey = c.Query("sort", spec.DefaultSort)
+	if query.sortKey != "" {
+		for _, key := range strings.Split(query.sortKey, ",") {
+			desc := strings.HasPrefix(key, "-")
+			column, ok := spec.Sortable[strings.TrimPrefix(key, "-")]
+			if !ok {
+				return query, badQuery("cannot sort by '%s'", strings.TrimPrefix(key, "-"))
+			}
+			query.Sort = append(query.Sort, SortField{Column: column, Desc: desc})
+		}
+	}
+
+	hasId := false
+	for _, field := range query.Sort {
+		hasId = hasId || field.Column == "id"
+	}
+	if !hasId {
+		// Keyset pagination needs a unique tiebreaker.
+		query.Sort = append(query.Sort, SortField{Column: "id"})
+	}
+
+	for name, filter := range spec.Filters {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+
+		condition, err := filter(value)
+		if err != nil {
+			return query, badQuery("invalid value for %s", name)
+		}
+		query.Conditions = append(query.Conditions, condition)
+	}
+
+	return query, nil
+}
+
+// Where narrows the query to rows where column equals value.
+func (q *ListQuery) Where(column string, value any) {
+	q.Conditions = append(q.Conditions, Condition{Column: column, Op: OpEquals, Value: value})
+}
+
+var schemaCache sync.Map
+
+func modelSchema[T any]() (*schema.Schema, error) {
+	return schema.Parse(new(T), &schemaCache, schema.NamingStrategy{})
+}
+
+// Paginate runs db with the conditions, ordering and paging of query and
+// returns one page of T along with the meta block for the response.
+func Paginate[T any](db *gorm.DB, query ListQuery) ([]T, ListMeta, error) {
+	meta := ListMeta{Limit: query.Limit, Offset: query.Offset}
+
+	s, err := modelSchema[T]()
+	if err != nil {
+		return nil, meta, err
+	}
+
+	db = db.Model(new(T))
+	for _, condition := range query.Conditions {
+		if condition.Op == OpContains {
+			escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+			pattern := "%" + strings.ToLower(escaper.Replace(fmt.Sprint(condition.Value))) + "%"
+			db = db.Where("LOWER("+condition.Column+`) LIKE ? ESCAPE '\'`, pattern)
+			continue
+		}
+		db = db.Where(condition.Column+" "+condition.Op+" ?", condition.Value)
+	}
+
+	if err := db.Session(&gorm.Session{}).Count(&meta.Total).Error; err != nil {
+		return nil, meta, err
+	}
+
+	page := db.Session(&gorm.Session{})
+	if query.Cursor != "" {
+		values, err := decodeCursor(s, query)
+		if err != nil {
+			return nil, meta, err
+		}
+		condition, args := keysetCondition(query.Sort, values)
+		page = page.Where(condition, args...)
+	} else if query.Offset > 0 {
+		page = page.Offset(query.Offset)
+	}
+
+	for _, field := range query.Sort {
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+		page = page.Order(field.Column + " " + direction)
+	}
+
+	items := []T{}
+	if err := page.Limit(query.Limit + 1).Find(&items).Error; err != nil {
+		return nil, meta, err
+	}
+
+	return pageOf(s, query, items, meta)
+}
+
+// PaginateSlice applies query to an in-memory slice the same way Paginate
+// applies it to a table.
+func PaginateSlice[T any](all []T, query ListQuery) ([]T, ListMeta, error) {
+	meta := ListMeta{Limit: query.Limit, Offset: query.Offset}
+
+	s, err := modelSchema[T]()
+	if err != nil {
+		return nil, meta, err
+	}
+
+	matched := []T{}
+	for _, item := range all {
+		ok, err := matches(s, item, query.Conditions)
+		if err != nil {
+			return nil, meta, err
+		}
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+	meta.Total = int64(len(matched))
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return compareRows(s, query.Sort, matched[i], rowValues(s, query.Sort, matched[j])) < 0
+	})
+
+	start := query.Offset
+	if query.Cursor != "" {
+		values, err := decodeCursor(s, query)
+		if err != nil {
+			return nil, meta, err
+		}
+
+		start = len(matched)
+		for i, item := range matched {
+			if compareRows(s, query.Sort, item, values) > 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + query.Limit + 1
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	return pageOf(s, query, matched[start:end], meta)
+}
+
+// pageOf trims the extra row fetched to detect a next page and turns it into
+// the next cursor.
+func pageOf[T any](s *schema.Schema, query ListQuery, items []T, meta ListMeta) ([]T, ListMeta, error) {
+	if len(items) > query.Limit {
+		items = items[:query.Limit]
+
+		cursor, err := encodeCursor(s, query, items[len(items)-1])
+		if err != nil {
+			return nil, meta, err
+		}
+		meta.NextCursor = cursor
+	}
+
+	return items, meta, nil
+}
+
+// keysetCondition builds "(a > ?) OR (a = ? AND b > ?) ..." so rows after the
+// cursor are selected regardless of mixed sort directions.
+func keysetCondition(sort []SortField, values []any) (string, []any) {
+	clauses := make([]string, 0, len(sort))
+	args := []any{}
+
+	for i, field := range sort {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, sort[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+
+		op := ">"
+		if field.Desc {
+			op = "<"
+		}
+		parts = append(parts, field.Column+" "+op+" ?")
+		args = append(args, values[i])
+
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return strings.Join(clauses, " OR "), args
+}
+
+func fieldValue(s *schema.Schema, column string, row any) (any, error) {
+	field := s.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("unknown column %s", column)
+	}
+
+	value, _ := field.ValueOf(context.Background(), reflect.ValueOf(row))
+	return value, nil
+}
+
+func rowValues(s *schema.Schema, sort []SortField, row any) []any {
+	values := make([]any, len(sort))
+	for i, field := range sort {
+		values[i], _ = fieldValue(s, field.Column, row)
+	}
+	return values
+}
+
+// compareRows orders row against the sort values of another row, honouring
+// the direction of every sort field.
+func compareRows(s *schema.Schema, sort []SortField, row any, values []any) int {
+	for i, field := range sort {
+		value, _ := fieldValue(s, field.Column, row)
+		if cmp := compareValues(value, values[i]); cmp != 0 {
+			if field.Desc {
+				return -cmp
+			}
+			return cmp
+		}
+	}
+	return 0
+}
+
+func matches(s *schema.Schema, row any, conditions []Condition) (bool, error) {
+	for _, condition := range conditions {
+		value, err := fieldValue(s, condition.Column, row)
+		if err != nil {
+			return false, err
+		}
+
+		var ok bool
+		switch condition.Op {
+		case OpContains:
+			ok = strings.Contains(strings.ToLower(fmt.Sprint(indirect(value))), strings.ToLower(fmt.Sprint(condition.Value)))
+		case OpEquals:
+			ok = compareValues(value, condition.Value) == 0
+		case OpGte:
+			ok = compareValues(value, condition.Value) >= 0
+		case OpLte:
+			ok = compareValues(value, condition.Value) <= 0
+		case OpAfter:
+			ok = compareValues(value, condition.Value) > 0
+		default:
+			return false, fmt.Errorf("unsupported operator %s", condition.Op)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func indirect(value any) any {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// compareValues compares numbers, strings and times of possibly different Go
+// types. Nil sorts first.
+func compareValues(a, b any) int {
+	a, b = indirect(a), indirect(b)
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if fa, ok := toFloat(va); ok {
+		if fb, ok := toFloat(vb); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func encodeCursor(s *schema.Schema, query ListQuery, last any) (string, error) {
+	cursor := listCursor{Sort: query.sortKey}
+
+	for _, field := range query.Sort {
+		value, err := fieldValue(s, field.Column, last)
+		if err != nil {
+			return "", err
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		cursor.Values = append(cursor.Values, raw)
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s *schema.Schema, query ListQuery) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, badQuery("invalid cursor")
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, badQuery("invalid cursor")
+	}
+
+	if cursor.Sort != query.sortKey || len(cursor.Values) != len(query.Sort) {
+		return nil, badQuery("cursor does not match the requested sort")
+	}
+
+	values := make([]any, 0, len(query.Sort))
+	for i, field := range query.Sort {
+		schemaField := s.LookUpField(field.Column)
+		if schemaField == nil {
+			return nil, fmt.Errorf("unknown column %s", field.Column)
+		}
+
+		// Decode into the column's Go type so drivers bind times and
+		// numbers correctly instead of comparing against strings.
+		value := reflect.New(schemaField.FieldType)
+		if err := json.Unmarshal(cursor.Values[i], value.Interface()); err != nil {
+			return nil, badQuery("invalid cursor")
+		}
+		values = append(values, value.Elem().Interface())
+	}
+
+	return values, nil
+}
+
+// FilterMinFloat keeps rows where column >= value.
+func FilterMinFloat(column string) Filter {
+	return func(value string) (Condition, error) {
+		n, err := strconv.ParseFloat(value, 64)
+		return Condition{Column: column, Op: OpGte, Value: n}, err
+	}
+}
+
+// FilterMaxFloat keeps rows where column <= value.
+func FilterMaxFloat(column string) Filter {
+	return func(value string) (Condition, error) {
+		n, err := strconv.ParseFloat(value, 64)
+		return Condition{Column: column, Op: OpLte, Value: n}, err
+	}
+}
+
+// FilterContains keeps rows where column contains value, ignoring case.
+func FilterContains(column string) Filter {
+	return func(value string) (Condition, error) {
+		return Condition{Column: column, Op: OpContains, Value: value}, nil
+	}
+}
+
+// FilterUint keeps rows where column equals the unsigned integer value.
+func FilterUint(column string) Filter {
+	return func(value string) (Condition, error) {
+		n, err := strconv.ParseUint(value, 10, 32)
+		return Condition{Column: column, Op: OpEquals, Value: uint(n)}, err
+	}
+}
+
+// FilterEquals keeps rows where column equals value.
+func FilterEquals(column string) Filter {
+	return func(value string) (Condition, error) {
+		return Condition{Column: column, Op: OpEquals, Value: value}, nil
+	}
+}
+
+// FilterAfter keeps rows where column is later than value, given either as
+// RFC 3339 or as a plain date.
+func FilterAfter(column string) Filter {
+	return func(value string) (Condition, error) {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, value)
+		}
+		return Condition{Column: column, Op: OpAfter, Value: t}, err
+	}
+}
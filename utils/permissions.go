@@ -0,0 +1,16 @@
+package utils
+
+import "github.com/gofiber/fiber/v2"
+
+const permissionsLocal = "permissions"
+
+func SetPermissions(c *fiber.Ctx, permissions []string) {
+	c.Locals(permissionsLocal, permissions)
+}
+
+// GetPermissions returns the permissions of the caller, once middleware.Auth
+// has resolved them.
+func GetPermissions(c *fiber.Ctx) ([]string, bool) {
+	permissions, ok := c.Locals(permissionsLocal).([]string)
+	return permissions, ok
+}
@@ -0,0 +1,14 @@
+package utils
+
+import "github.com/gofiber/fiber/v2"
+
+const resourceLocal = "resource"
+
+func SetResource(c *fiber.Ctx, resource any) {
+	c.Locals(resourceLocal, resource)
+}
+
+// GetResource returns the record loaded by middleware.RequireOwnership.
+func GetResource[T any](c *fiber.Ctx) T {
+	return c.Locals(resourceLocal).(T)
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// MinPasswordClasses is how many of lowercase letters, uppercase
+	// letters, digits and symbols a password must mix.
+	MinPasswordClasses = 2
+	// MinPasswordEntropy is the strength in bits a password must reach,
+	// estimated from its distinct characters and the classes it uses.
+	MinPasswordEntropy = 45
+)
+
+// UsernamePattern and SKUPattern are the formats the username and sku
+// rules accept.
+var (
+	UsernamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+	SKUPattern      = regexp.MustCompile(`^[A-Z0-9]{2,10}(?:-[A-Z0-9]{2,10}){0,3}$`)
+)
+
+// rules are the custom validate tags available to every input.
+var rules = []Rule{
+	{
+		Tag:      "username",
+		Validate: stringRule(UsernamePattern.MatchString),
+		Messages: map[string]string{
+			"en": "{0} may only contain letters, digits, '.', '_' and '-', and must start and end with a letter or digit",
+			"id": "{0} hanya boleh berisi huruf, angka, '.', '_' dan '-', serta harus diawali dan diakhiri huruf atau angka",
+		},
+	},
+	{
+		Tag:      "password",
+		Validate: stringRule(strongPassword),
+		Messages: map[string]string{
+			"en": fmt.Sprintf("{0} is too weak, mix at least %d of lowercase letters, uppercase letters, digits and symbols and avoid repeating characters", MinPasswordClasses),
+			"id": fmt.Sprintf("{0} terlalu lemah, gunakan minimal %d dari huruf kecil, huruf besar, angka dan simbol serta hindari karakter berulang", MinPasswordClasses),
+		},
+	},
+	{
+		Tag:      "sku",
+		Validate: stringRule(SKUPattern.MatchString),
+		Messages: map[string]string{
+			"en": "{0} must be groups of 2 to 10 uppercase letters or digits separated by '-', such as ABC-123",
+			"id": "{0} harus berupa kelompok 2 sampai 10 huruf besar atau angka yang dipisahkan '-', misalnya ABC-123",
+		},
+	},
+	{
+		Tag:      "currency",
+		Validate: stringRule(currencyCode),
+		Messages: map[string]string{
+			"en": "{0} must be an ISO 4217 currency code such as IDR or USD",
+			"id": "{0} harus berupa kode mata uang ISO 4217 seperti IDR atau USD",
+		},
+	},
+}
+
+// uniqueMessages are the messages of the unique rule, which every
+// Validator binds to its own lookups.
+var uniqueMessages = map[string]string{
+	"en": "{0} is already taken",
+	"id": "{0} sudah digunakan",
+}
+
+// stringRule validates string fields with valid.
+func stringRule(valid func(string) bool) validator.FuncCtx {
+	return func(_ context.Context, fl validator.FieldLevel) bool {
+		return valid(fl.Field().String())
+	}
+}
+
+// strongPassword requires MinPasswordClasses character classes and
+// MinPasswordEntropy bits. Repeated characters add nothing, so
+// "aaaaaaaaaaaa" is as weak as "a".
+func strongPassword(password string) bool {
+	var lower, upper, digit, symbol bool
+	distinct := map[rune]struct{}{}
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+		distinct[r] = struct{}{}
+	}
+
+	classes, pool := 0, 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}} {
+		if class.used {
+			classes++
+			pool += class.size
+		}
+	}
+	if classes < MinPasswordClasses {
+		return false
+	}
+
+	return float64(len(distinct))*math.Log2(float64(pool)) >= MinPasswordEntropy
+}
+
+var currencyValidator = validator.New()
+
+func currencyCode(code string) bool {
+	return currencyValidator.Var(code, "iso4217") == nil
+}
+
+// UniqueLookup reports whether a record with value already exists.
+type UniqueLookup func(ctx context.Context, value string) (bool, error)
+
+type lookupFailureKey struct{}
+
+// lookupFailure keeps the first failed lookup of a Validate call, so the
+// call reports an error rather than a verdict on the field.
+type lookupFailure struct {
+	err error
+}
+
+// unique fails when the value is already taken, or could not be looked up.
+// It only spares clients a round trip, the unique constraints of the
+// database still decide when two requests race.
+func (v *Validator) unique(ctx context.Context, fl validator.FieldLevel) bool {
+	failed := func(err error) bool {
+		if failure, ok := ctx.Value(lookupFailureKey{}).(*lookupFailure); ok && failure.err == nil {
+			failure.err = err
+		}
+		return false
+	}
+
+	lookup, ok := v.lookups[fl.Param()]
+	if !ok {
+		return failed(fmt.Errorf("no unique lookup for %q", fl.Param()))
+	}
+
+	exists, err := lookup(ctx, fl.Field().String())
+	if err != nil {
+		return failed(fmt.Errorf("unique lookup %s: %w", fl.Param(), err))
+	}
+	return !exists
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for signed tokens that were tampered with,
+// signed for another purpose or have expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// GenerateOpaqueToken returns a random URL-safe token suitable for refresh
+// tokens and other single-purpose secrets.
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of token. Only the hash is
+// persisted so a database leak does not expose usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignToken returns a URL-safe token carrying payload until expires, signed
+// with secret. Purpose is part of the signature, so a token signed for one
+// purpose is never accepted for another.
+func SignToken(secret []byte, purpose, payload string, expires time.Time) string {
+	body := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(expires.Unix(), 10) + "." + payload))
+	return body + "." + base64.RawURLEncoding.EncodeToString(signature(secret, purpose, body))
+}
+
+// VerifySignedToken returns the payload of a token from SignToken.
+func VerifySignedToken(secret []byte, purpose, token string) (string, error) {
+	body, encoded, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal(sig, signature(secret, purpose, body)) {
+		return "", ErrInvalidToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	expiry, payload, ok := strings.Cut(string(decoded), ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return "", ErrInvalidToken
+	}
+
+	return payload, nil
+}
+
+func signature(secret []byte, purpose, body string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + "." + body))
+	return mac.Sum(nil)
+}
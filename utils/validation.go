@@ -1,75 +1,194 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go-task/apperr"
+	"reflect"
 	"strings"
 
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/id"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	id_translations "github.com/go-playground/validator/v10/translations/id"
 	"github.com/gofiber/fiber/v2"
 )
 
-type (
-	ErrorResponse struct {
-		Error       bool
-		FailedField string
-		Tag         string
-		Value       any
-	}
+// FieldError describes one invalid field of a request body. Field is the
+// JSON name of the field, Rule the failed validation tag and Param its
+// parameter, such as "8" for min=8.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
 
-	XValidator struct {
-		validator *validator.Validate
-	}
+// Languages are the languages validation messages are available in, the
+// first one is the default.
+var Languages = []string{"en", "id"}
+
+// validationMessages summarize a failed validation, per language.
+var validationMessages = map[string]string{
+	"en": "Some fields are invalid.",
+	"id": "Beberapa field tidak valid.",
+}
 
-	GlobalErrorHandlerResp struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
+// Validator checks data against its validate tags, the custom rules
+// included. The unique rule looks values up with the lookups the validator
+// was created with.
+type Validator struct {
+	validate    *validator.Validate
+	translators *ut.UniversalTranslator
+	lookups     map[string]UniqueLookup
+}
+
+// NewValidator returns a validator whose unique=name tags check values with
+// lookups[name], where name is usually table.column such as users.email.
+func NewValidator(lookups map[string]UniqueLookup) *Validator {
+	v := &Validator{
+		validate:    validator.New(),
+		translators: ut.New(en.New(), en.New(), id.New()),
+		lookups:     lookups,
 	}
-)
 
-var validate = validator.New()
+	// Report fields under the names clients send them with.
+	v.validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return field.Name
+		}
+		return name
+	})
+
+	register := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"id": id_translations.RegisterDefaultTranslations,
+	}
+	for lang, fn := range register {
+		trans, _ := v.translators.GetTranslator(lang)
+		if err := fn(v.validate, trans); err != nil {
+			panic(err)
+		}
+	}
 
-func (v XValidator) Validate(data interface{}) []ErrorResponse {
-	validationErrors := []ErrorResponse{}
+	for _, rule := range rules {
+		if err := v.RegisterRule(rule); err != nil {
+			panic(err)
+		}
+	}
+	if err := v.RegisterRule(Rule{Tag: "unique", Validate: v.unique, Messages: uniqueMessages}); err != nil {
+		panic(err)
+	}
+	return v
+}
 
-	errs := validate.Struct(data)
-	if errs != nil {
-		for _, err := range errs.(validator.ValidationErrors) {
+// Rule is a custom validation tag. Messages holds its message per
+// language, where {0} stands for the field and {1} for the tag parameter.
+type Rule struct {
+	Tag      string
+	Validate validator.FuncCtx
+	Messages map[string]string
+}
 
-			var elem ErrorResponse
+// RegisterRule makes rule available as a validate tag.
+func (v *Validator) RegisterRule(rule Rule) error {
+	if err := v.validate.RegisterValidationCtx(rule.Tag, rule.Validate); err != nil {
+		return err
+	}
 
-			elem.FailedField = err.Field()
-			elem.Tag = err.Tag()
-			elem.Value = err.Value()
-			elem.Error = true
+	for _, lang := range Languages {
+		message, ok := rule.Messages[lang]
+		if !ok {
+			return fmt.Errorf("rule %s has no %s message", rule.Tag, lang)
+		}
 
-			validationErrors = append(validationErrors, elem)
+		trans, _ := v.translators.GetTranslator(lang)
+		err := v.validate.RegisterTranslation(rule.Tag, trans,
+			func(trans ut.Translator) error {
+				return trans.Add(rule.Tag, message, true)
+			},
+			func(trans ut.Translator, fe validator.FieldError) string {
+				message, _ := trans.T(rule.Tag, fe.Field(), fe.Param())
+				return message
+			},
+		)
+		if err != nil {
+			return err
 		}
 	}
 
-	return validationErrors
+	return nil
 }
 
-var myValidator = &XValidator{
-	validator: validate,
+// Language picks the language of validation messages from the
+// Accept-Language header of the request.
+func Language(c *fiber.Ctx) string {
+	if lang := c.AcceptsLanguages(Languages...); lang != "" {
+		return lang
+	}
+	return Languages[0]
 }
 
-func ValidationHandler(data interface{}) error {
-	if errs := myValidator.Validate(data); len(errs) > 0 && errs[0].Error {
-		errMsgs := make([]string, 0)
-
-		for _, err := range errs {
-			errMsgs = append(errMsgs, fmt.Sprintf(
-				"[%s]: '%v' | Needs to implement '%s'",
-				err.FailedField,
-				err.Value,
-				err.Tag,
-			))
-		}
+// Validate checks data against its validate tags and returns the invalid
+// fields with messages in lang. Rules looking up the database run with ctx.
+// The error is set when the fields could not be checked, such as when a
+// lookup failed.
+func (v *Validator) Validate(ctx context.Context, data interface{}, lang string) ([]FieldError, error) {
+	failure := &lookupFailure{}
+	err := v.validate.StructCtx(context.WithValue(ctx, lookupFailureKey{}, failure), data)
+	if failure.err != nil {
+		return nil, failure.err
+	}
+	if err == nil {
+		return nil, nil
+	}
 
-		return &fiber.Error{
-			Code:    fiber.ErrBadRequest.Code,
-			Message: strings.Join(errMsgs, " and "),
-		}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil, err
+	}
+
+	trans, _ := v.translators.GetTranslator(lang)
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return fields, nil
+}
+
+// fieldPath drops the name of the validated struct from a namespace such as
+// "CreateProductInput.name".
+func fieldPath(namespace string) string {
+	_, path, found := strings.Cut(namespace, ".")
+	if !found {
+		return namespace
+	}
+	return path
+}
+
+// Handle validates data and reports the invalid fields as a validation
+// error, in the language the client asked for.
+func (v *Validator) Handle(c *fiber.Ctx, data interface{}) error {
+	lang := Language(c)
+	fields, err := v.Validate(c.UserContext(), data, lang)
+	if err != nil {
+		return apperr.Internal("Failed to validate request", err)
+	}
+	if len(fields) > 0 {
+		return apperr.Validation(validationMessages[lang], fields)
 	}
 
 	return nil
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"go-task/repository"
+	"log/slog"
+	"time"
+)
+
+// purgeExpired deletes expired refresh tokens, revocation entries, password
+// reset tokens, signing keys and rate limit windows every interval until ctx
+// is cancelled.
+func purgeExpired(ctx context.Context, repos repository.Repositories, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := repos.Sessions.PurgeExpired(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "Failed to purge expired sessions", "error", err)
+			}
+			if err := repos.PasswordResets.PurgeExpired(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "Failed to purge expired password reset tokens", "error", err)
+			}
+			if err := repos.SigningKeys.PurgeExpired(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "Failed to purge expired signing keys", "error", err)
+			}
+			if err := repos.RateLimits.PurgeExpired(ctx, time.Now()); err != nil {
+				slog.ErrorContext(ctx, "Failed to purge expired rate limits", "error", err)
+			}
+		}
+	}
+}